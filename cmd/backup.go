@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "backup <directory or zip/tar archive>...",
+		Short:   "store media once by content and record a snapshot of their paths",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c backup.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return backup.Run(&c, args)
+		},
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.StringP("output", "o", "", "the store directory, or s3://bucket/prefix, sftp://host/path and webdav://host/path")
+	flags.String("report", "", "write the run result as JSON to the file")
+	flags.BoolP("dry-run", "n", false, "show what would be stored without writing the store")
+
+	_ = subCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(subCmd)
+}
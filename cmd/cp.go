@@ -1,24 +1,49 @@
 package cmd
 
 import (
+	"time"
+
+	"github.com/enjoypi/bkpic/cmd/internal/cp"
+	"github.com/enjoypi/bkpic/parity"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	subCmd := &cobra.Command{
-		Use:     "cp",
+		Use:     "cp <directory or zip/tar archive>...",
 		Short:   "remove duplication files",
 		PreRunE: preRunE,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return cmd.Help()
-			// return internal.cp.Run(rootViper, args)
+			var c cp.TidyConfig
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return cp.Run(&c, args)
 		},
 		Args: cobra.MinimumNArgs(-1),
 	}
 
 	flags := subCmd.Flags()
 	flags.BoolP("move", "m", false, "move")
-	flags.StringP("output", "o", "", "the output directory")
+	flags.StringP("output", "o", "", "the output directory, or s3://bucket/prefix, sftp://host/path and webdav://host/path")
+	flags.String("layout", "{year}/{month}", "the directories in output by {year}, {month}, {day}, {country}, {region}, {city}, {event} and {album}, "+
+		"the places named by the nearest city within 200km, of the bundled major ones or --geo.cities")
+	flags.String("report", "", "write the run result as JSON to the file")
+	flags.Bool("resume", false, "skip the files handled by the interrupted run and continue")
+	flags.Bool("moves", false, "print the files moved or renamed within output since they were written")
+	flags.Bool("watch", false, "keep importing the new files of the input directories until interrupted")
+	flags.Duration("stable", 5*time.Second, "with --watch, how long the size of a new file stays unchanged before it is imported")
+	flags.String("nodate.policy", "unsorted", "what to do with media without shooting time: unsorted, filedate or skip")
+	flags.String("nodate.dir", "unsorted", "the directory in output for undated media, keeping their source-relative paths")
+	flags.String("nodate.filedate", "modify", "the file date used by the filedate policy: modify or create")
+	flags.String("confidence.min", "low", "the lowest confidence of shooting times to file media by date: low, medium or high")
+	flags.String("confidence.review", "", "the directory in output for media below the confidence, they are skipped if empty")
+	flags.String("conflict.pattern", "{name}_{n}{ext}", "the name for a file whose target exists with different content")
+	flags.Duration("event.gap", 8*time.Hour, "the gap of shooting time which starts a new {event}")
+	flags.Float64("event.distance", 50, "the distance in km which starts a new {event}")
+	flags.StringSlice("album.ignored", nil, "the generic directory names which never name an {album} or {event}, a built-in list if empty")
+	flags.Int("parity.redundancy", 0, "the size of parity in percent of the data of each written directory, no parity if 0")
+	flags.Int("parity.block", parity.DefaultBlockSize, "the block size of parity, the unit of damage and repair")
 
 	_ = subCmd.MarkFlagRequired("output")
 	rootCmd.AddCommand(subCmd)
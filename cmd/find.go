@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/find"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "find",
+		Short:   "find media by the place where they were shot",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c find.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return find.Run(&c, args)
+		},
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.String("country", "", "the name or the code of country")
+	flags.String("region", "", "the name of region")
+	flags.String("city", "", "the name of city")
+
+	rootCmd.AddCommand(subCmd)
+}
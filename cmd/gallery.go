@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/gallery"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "gallery <archive>",
+		Short:   "export the archive as static HTML pages by its directories, browsable offline without any software",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c gallery.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return gallery.Run(&c, args[0])
+		},
+		Args: cobra.ExactArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.StringP("output", "o", "", "the directory of the site")
+	flags.String("cache", "", "the directory of thumbnails, the cache directory of the user if empty")
+	flags.Bool("copy", true, "copy the originals into the site, --copy=false links them relative to the site, which works only beside the archive")
+	flags.String("report", "", "write the run result as JSON to the file")
+
+	_ = subCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(subCmd)
+}
@@ -0,0 +1,124 @@
+package archivesync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+// state is the content both sides agreed on after the last sync, by relative paths,
+// it tells the deleted files from the new ones of the other side.
+type state struct {
+	Files map[string]*stateFile `json:"files"`
+}
+
+// stateFile is the content of a path, with the file of each side when synced,
+// so the files of both sides unchanged since are not read again
+type stateFile struct {
+	SHA256 string `json:"sha256"`
+	// This is of the side keeping the state, Other is of the other side, they are of a and b when loaded
+	This  stamp `json:"this"`
+	Other stamp `json:"other"`
+}
+
+// stamp tells whether a file is changed
+type stamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func newStamp(info os.FileInfo) stamp {
+	return stamp{Size: info.Size(), ModTime: info.ModTime()}
+}
+
+func (s stamp) unchanged(info os.FileInfo) bool {
+	return s.Size == info.Size() && s.ModTime.Equal(info.ModTime())
+}
+
+// swapped is st kept by the other side
+func (st *state) swapped() *state {
+	other := &state{Files: make(map[string]*stateFile, len(st.Files))}
+	for rel, f := range st.Files {
+		other.Files[rel] = &stateFile{SHA256: f.SHA256, This: f.Other, Other: f.This}
+	}
+	return other
+}
+
+// sum returns the content of rel when synced if the file of side sd is unchanged since
+func (st *state) sum(rel string, sd *side, info os.FileInfo) (string, bool) {
+	f, ok := st.Files[rel]
+	if !ok {
+		return "", false
+	}
+	s := f.This
+	if sd.b {
+		s = f.Other
+	}
+	return f.SHA256, s.unchanged(info)
+}
+
+// statePath is the state in s of the sync with the other location
+func statePath(s fs.Storage, root string, other string) string {
+	sum := sha256.Sum256([]byte(other))
+	return fs.Join(s, root, index.MetaDir, "sync-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadState reads the state kept by a, or by b if a has none, as of a
+func loadState(a, b *side) (*state, error) {
+	for _, sd := range []*side{a, b} {
+		other := b
+		if sd == b {
+			other = a
+		}
+
+		r, err := sd.storage.Open(statePath(sd.storage, sd.root, other.location))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		var st state
+		if err := json.NewDecoder(r).Decode(&st); err != nil {
+			return nil, err
+		}
+		if st.Files == nil {
+			continue
+		}
+		if sd == b {
+			return st.swapped(), nil
+		}
+		return &st, nil
+	}
+	return &state{Files: make(map[string]*stateFile)}, nil
+}
+
+// save writes the state of a to both sides
+func (st *state) save(a, b *side) error {
+	for _, sd := range []*side{a, b} {
+		other, kept := b, st
+		if sd == b {
+			other, kept = a, st.swapped()
+		}
+
+		w, err := sd.storage.Create(statePath(sd.storage, sd.root, other.location), time.Time{})
+		if err != nil {
+			return err
+		}
+		if err := json.NewEncoder(w).Encode(kept); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package archivesync
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+)
+
+const (
+	outcomeCopied   report.Outcome = "copied"
+	outcomeRenamed  report.Outcome = "renamed"
+	outcomeDeleted  report.Outcome = "deleted"
+	outcomeConflict report.Outcome = "conflict"
+)
+
+type Config struct {
+	DryRun bool `mapstructure:"dry-run"`
+	// Mirror makes B the same as A, otherwise the changes of both sides are synced
+	Mirror bool
+	Report string
+}
+
+// side is an archive of the sync, its files by the slash-separated relative paths
+type side struct {
+	location string
+	storage  fs.Storage
+	root     string
+	files    map[string]*index.Medium
+	// b is set for the second side, whose files are the others of the state
+	b bool
+}
+
+func openSide(location string) (*side, error) {
+	idx, err := index.NewIndex(location)
+	if err != nil {
+		return nil, err
+	}
+
+	sd := &side{
+		location: location,
+		storage:  idx.Storage(),
+		root:     idx.Directory(),
+		files:    make(map[string]*index.Medium),
+	}
+	if fs.IsLocal(sd.storage) {
+		sd.location = sd.root
+	}
+	// the sums written by cp are taken for the files unchanged since, not to read them
+	mf, err := index.LoadManifest(sd.storage, sd.root)
+	if err != nil {
+		zap.L().Info("invalid manifest", zap.String("location", location), zap.Error(err))
+		mf = &index.Manifest{}
+	}
+	if err := mf.Sums(idx); err != nil {
+		return nil, err
+	}
+	for _, media := range idx.GetMediaBySize() {
+		for _, m := range media {
+			rel, err := fs.Rel(sd.storage, sd.root, m.FullPath)
+			if err != nil {
+				return nil, err
+			}
+			sd.files[rel] = m
+		}
+	}
+	return sd, nil
+}
+
+func (sd *side) path(rel string) string {
+	return fs.Join(sd.storage, sd.root, rel)
+}
+
+// sum returns the SHA256 of rel in hex, or an empty string if it is absent.
+// The sum of the manifest or of the last sync is taken for a file of the same size and modification time.
+func (sd *side) sum(rel string, base *state) string {
+	m, ok := sd.files[rel]
+	if !ok {
+		return ""
+	}
+	if len(m.SHA256) == 0 {
+		if sum, ok := base.sum(rel, sd, m.FileInfo); ok {
+			m.SHA256, _ = hex.DecodeString(sum)
+		} else {
+			m.SumSHA256()
+		}
+	}
+	return hex.EncodeToString(m.SHA256)
+}
+
+// Run syncs the archives a and b by their contents, the changes are planned before applied.
+func Run(c *Config, a, b string) error {
+	sa, err := openSide(a)
+	if err != nil {
+		return err
+	}
+	sb, err := openSide(b)
+	if err != nil {
+		return err
+	}
+	sb.b = true
+	if sa.root == sb.root && fs.SameStorage(sa.storage, sb.storage) {
+		return fmt.Errorf("sync %s with itself", sa.root)
+	}
+
+	base, err := loadState(sa, sb)
+	if err != nil {
+		return err
+	}
+
+	progress.Start("sync")
+	defer progress.Stop()
+
+	p := newPlan(c, sa, sb, base)
+	res := report.New("sync", outcomeCopied, outcomeRenamed, outcomeDeleted, outcomeConflict)
+	for _, act := range p.actions {
+		if c.DryRun || act.kind == outcomeConflict {
+			fmt.Println(act)
+		}
+		if act.kind == outcomeConflict {
+			res.Add(outcomeConflict, 1)
+			continue
+		}
+		if c.DryRun {
+			res.Add(act.kind, 1)
+			continue
+		}
+
+		if err := act.apply(); err != nil {
+			zap.L().Info("failed to sync", zap.Stringer("action", act), zap.Error(err))
+			res.Fail(act.to.path(act.dst), err.Error())
+			p.failed[act.dst] = true
+			continue
+		}
+		zap.L().Info("synced", zap.Stringer("action", act))
+		res.Add(act.kind, 1)
+	}
+
+	if !c.DryRun {
+		p.cleanDirs()
+		if err := p.nextState().save(sa, sb); err != nil {
+			return err
+		}
+	}
+	progress.Stop()
+
+	res.Finish()
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if c.Report != "" {
+		if err := res.WriteJSON(c.Report); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
+
+// action changes dst of side to, copied from src of side from, or renamed from src of to
+type action struct {
+	kind     report.Outcome
+	from, to *side
+	src, dst string
+	sum      string
+}
+
+func (act *action) String() string {
+	switch act.kind {
+	case outcomeCopied:
+		return fmt.Sprintf("copy\t%s\t=>\t%s", act.from.path(act.src), act.to.path(act.dst))
+	case outcomeRenamed:
+		return fmt.Sprintf("rename\t%s\t=>\t%s", act.to.path(act.src), act.to.path(act.dst))
+	case outcomeDeleted:
+		return fmt.Sprintf("delete\t%s", act.to.path(act.dst))
+	default:
+		return fmt.Sprintf("conflict\t%s\t<=>\t%s", act.from.path(act.src), act.to.path(act.dst))
+	}
+}
+
+func (act *action) apply() error {
+	switch act.kind {
+	case outcomeCopied:
+		if err := fs.CopyFile(act.from.storage, act.from.path(act.src), act.to.storage, act.to.path(act.dst)); err != nil {
+			return err
+		}
+		progress.Add(progress.Copied, 1, act.from.files[act.src].FileInfo.Size())
+		return nil
+	case outcomeRenamed:
+		return act.to.storage.Rename(act.to.path(act.src), act.to.path(act.dst))
+	case outcomeDeleted:
+		return act.to.storage.Remove(act.to.path(act.dst))
+	}
+	return nil
+}
+
+// plan is the actions of a sync, applied in order:
+// copies within a side, renames, copies between the sides, then deletions.
+type plan struct {
+	a, b    *side
+	base    *state
+	actions []*action
+	// the resulting content by path, empty for deleted ones
+	result map[string]string
+	// the paths in conflict or failed keep their last state
+	failed map[string]bool
+}
+
+func newPlan(c *Config, a, b *side, base *state) *plan {
+	p := &plan{a: a, b: b, base: base, result: make(map[string]string), failed: make(map[string]bool)}
+
+	paths := make(map[string]bool)
+	for rel := range a.files {
+		paths[rel] = true
+	}
+	for rel := range b.files {
+		paths[rel] = true
+	}
+	if !c.Mirror {
+		for rel := range base.Files {
+			paths[rel] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for rel := range paths {
+		sorted = append(sorted, rel)
+	}
+	sort.Strings(sorted)
+
+	progress.AddTotal(int64(len(sorted)), 0)
+
+	// the changes to b and to a
+	toB, toA := newChanges(a, b), newChanges(b, a)
+	for _, rel := range sorted {
+		sa, sb := a.sum(rel, base), b.sum(rel, base)
+		progress.Add(progress.Processed, 1, 0)
+		if sa == sb {
+			p.result[rel] = sa
+			continue
+		}
+		if c.Mirror {
+			toB.change(rel, sa)
+			p.result[rel] = sa
+			continue
+		}
+
+		var old string
+		if e, ok := base.Files[rel]; ok {
+			old = e.SHA256
+		}
+		switch old {
+		case sa:
+			toA.change(rel, sb)
+			p.result[rel] = sb
+		case sb:
+			toB.change(rel, sa)
+			p.result[rel] = sa
+		default:
+			p.actions = append(p.actions, &action{kind: outcomeConflict, from: a, to: b, src: rel, dst: rel})
+			p.failed[rel] = true
+		}
+	}
+
+	p.actions = append(p.actions, toB.actions(base)...)
+	p.actions = append(p.actions, toA.actions(base)...)
+	return p
+}
+
+// changes are the new contents of the paths of side to, the files of from are copied
+type changes struct {
+	from, to *side
+	// the new content by path, empty for deletion
+	sums map[string]string
+}
+
+func newChanges(from, to *side) *changes {
+	return &changes{from: from, to: to, sums: make(map[string]string)}
+}
+
+func (ch *changes) change(rel string, sum string) {
+	ch.sums[rel] = sum
+}
+
+// actions turns the changes into actions, the contents which are already in to are renamed or copied there
+func (ch *changes) actions(base *state) []*action {
+	var paths []string
+	for rel := range ch.sums {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	// the files of to which are deleted, and the ones which stay, by content
+	deleted := make(map[string][]string)
+	staying := make(map[string]string)
+	for _, rel := range paths {
+		if ch.sums[rel] == "" {
+			if _, ok := ch.to.files[rel]; ok {
+				sum := ch.to.sum(rel, base)
+				deleted[sum] = append(deleted[sum], rel)
+			}
+		}
+	}
+	for rel := range ch.to.files {
+		if _, changed := ch.sums[rel]; !changed {
+			staying[ch.to.sum(rel, base)] = rel
+		}
+	}
+
+	var local, renames, copies, deletions []*action
+	for _, rel := range paths {
+		sum := ch.sums[rel]
+		if sum == "" {
+			continue
+		}
+
+		_, exists := ch.to.files[rel]
+		if olds := deleted[sum]; len(olds) > 0 && !exists {
+			renames = append(renames, &action{kind: outcomeRenamed, to: ch.to, src: olds[0], dst: rel, sum: sum})
+			deleted[sum] = olds[1:]
+			continue
+		}
+		if src, ok := staying[sum]; ok {
+			local = append(local, &action{kind: outcomeCopied, from: ch.to, to: ch.to, src: src, dst: rel, sum: sum})
+			continue
+		}
+		copies = append(copies, &action{kind: outcomeCopied, from: ch.from, to: ch.to, src: rel, dst: rel, sum: sum})
+	}
+	for _, olds := range deleted {
+		for _, rel := range olds {
+			deletions = append(deletions, &action{kind: outcomeDeleted, to: ch.to, dst: rel})
+		}
+	}
+	sort.Slice(deletions, func(i, j int) bool { return deletions[i].dst < deletions[j].dst })
+
+	actions := append(local, renames...)
+	actions = append(actions, copies...)
+	return append(actions, deletions...)
+}
+
+// cleanDirs removes the directories emptied by renames and deletions
+func (p *plan) cleanDirs() {
+	for _, act := range p.actions {
+		if act.kind != outcomeRenamed && act.kind != outcomeDeleted {
+			continue
+		}
+		rel := act.dst
+		if act.kind == outcomeRenamed {
+			rel = act.src
+		}
+		for dir := filepath.Dir(filepath.FromSlash(rel)); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if err := act.to.storage.Remove(act.to.path(filepath.ToSlash(dir))); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// nextState is the content both sides agree on after the sync
+func (p *plan) nextState() *state {
+	next := &state{Files: make(map[string]*stateFile)}
+	for rel, sum := range p.result {
+		if p.failed[rel] {
+			if e, ok := p.base.Files[rel]; ok {
+				next.Files[rel] = e
+			}
+			continue
+		}
+		if sum == "" {
+			continue
+		}
+
+		f := &stateFile{SHA256: sum}
+		if info, err := p.a.storage.Stat(p.a.path(rel)); err == nil {
+			f.This = newStamp(info)
+		}
+		if info, err := p.b.storage.Stat(p.b.path(rel)); err == nil {
+			f.Other = newStamp(info)
+		}
+		next.Files[rel] = f
+	}
+	return next
+}
@@ -0,0 +1,236 @@
+package archivesync
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/enjoypi/bkpic/index"
+)
+
+// files are the contents of a side or a state by path, a content is named by a letter
+type files map[string]string
+
+func sum(content string) string {
+	return hex.EncodeToString([]byte(content))
+}
+
+// newSide is an archive whose files are hashed already, so nothing is read
+func newSide(location string, fs files) *side {
+	sd := &side{location: location, files: make(map[string]*index.Medium)}
+	for rel, content := range fs {
+		sd.files[rel] = &index.Medium{FullPath: rel, SHA256: []byte(content)}
+	}
+	return sd
+}
+
+func newState(fs files) *state {
+	st := &state{Files: make(map[string]*stateFile)}
+	for rel, content := range fs {
+		st.Files[rel] = &stateFile{SHA256: sum(content)}
+	}
+	return st
+}
+
+// describe tells an action by its kind and the sides of its paths
+func describe(act *action) string {
+	switch act.kind {
+	case outcomeCopied:
+		return fmt.Sprintf("copy %s:%s %s:%s", act.from.location, act.src, act.to.location, act.dst)
+	case outcomeRenamed:
+		return fmt.Sprintf("rename %s:%s %s", act.to.location, act.src, act.dst)
+	case outcomeDeleted:
+		return fmt.Sprintf("delete %s:%s", act.to.location, act.dst)
+	default:
+		return fmt.Sprintf("conflict %s", act.dst)
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		mirror  bool
+		base    files
+		a, b    files
+		actions []string
+		// result is the content both sides agree on, without the conflicts
+		result files
+	}{
+		{
+			name:    "unchanged",
+			base:    files{"x": "1"},
+			a:       files{"x": "1"},
+			b:       files{"x": "1"},
+			actions: nil,
+			result:  files{"x": "1"},
+		},
+		{
+			name:    "new on both sides",
+			a:       files{"x": "1"},
+			b:       files{"y": "2"},
+			actions: []string{"copy A:x B:x", "copy B:y A:y"},
+			result:  files{"x": "1", "y": "2"},
+		},
+		{
+			name:    "moved in A",
+			base:    files{"x": "1"},
+			a:       files{"d/y": "1"},
+			b:       files{"x": "1"},
+			actions: []string{"rename B:x d/y"},
+			result:  files{"d/y": "1", "x": ""},
+		},
+		{
+			name:    "moved in B",
+			base:    files{"x": "1", "z": "3"},
+			a:       files{"x": "1", "z": "3"},
+			b:       files{"y": "1", "z": "3"},
+			actions: []string{"rename A:x y"},
+			result:  files{"x": "", "y": "1", "z": "3"},
+		},
+		{
+			name:    "copied in A",
+			base:    files{"x": "1"},
+			a:       files{"x": "1", "y": "1"},
+			b:       files{"x": "1"},
+			actions: []string{"copy B:x B:y"},
+			result:  files{"x": "1", "y": "1"},
+		},
+		{
+			name:    "deleted in A",
+			base:    files{"x": "1", "z": "3"},
+			a:       files{"x": "1"},
+			b:       files{"x": "1", "z": "3"},
+			actions: []string{"delete B:z"},
+			result:  files{"x": "1", "z": ""},
+		},
+		{
+			name:    "deleted in B",
+			base:    files{"x": "1", "z": "3"},
+			a:       files{"x": "1", "z": "3"},
+			b:       files{"z": "3"},
+			actions: []string{"delete A:x"},
+			result:  files{"x": "", "z": "3"},
+		},
+		{
+			name:    "deleted on both sides",
+			base:    files{"x": "1"},
+			a:       files{},
+			b:       files{},
+			actions: nil,
+			result:  files{"x": ""},
+		},
+		{
+			name:    "edited in A",
+			base:    files{"x": "1"},
+			a:       files{"x": "2"},
+			b:       files{"x": "1"},
+			actions: []string{"copy A:x B:x"},
+			result:  files{"x": "2"},
+		},
+		{
+			name:    "edited on both sides",
+			base:    files{"x": "1", "y": "3"},
+			a:       files{"x": "2", "y": "3"},
+			b:       files{"x": "4", "y": "3"},
+			actions: []string{"conflict x"},
+			result:  files{"y": "3"},
+		},
+		{
+			name:    "edited in A, deleted in B",
+			base:    files{"x": "1"},
+			a:       files{"x": "2"},
+			b:       files{},
+			actions: []string{"conflict x"},
+			result:  files{},
+		},
+		{
+			name:    "new on both sides, different",
+			a:       files{"x": "1"},
+			b:       files{"x": "2"},
+			actions: []string{"conflict x"},
+			result:  files{},
+		},
+		{
+			name:    "mirror",
+			mirror:  true,
+			base:    files{"x": "1"},
+			a:       files{"x": "2", "y": "5"},
+			b:       files{"x": "4", "z": "3", "w": "5"},
+			actions: []string{"rename B:w y", "copy A:x B:x", "delete B:z"},
+			result:  files{"x": "2", "y": "5", "z": "", "w": ""},
+		},
+		{
+			name:    "mirror ignores the state",
+			mirror:  true,
+			base:    files{"x": "1"},
+			a:       files{},
+			b:       files{"y": "2"},
+			actions: []string{"delete B:y"},
+			result:  files{"y": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := newSide("A", tt.a), newSide("B", tt.b)
+			p := newPlan(&Config{Mirror: tt.mirror}, a, b, newState(tt.base))
+
+			var actions []string
+			for _, act := range p.actions {
+				actions = append(actions, describe(act))
+			}
+			if !reflect.DeepEqual(actions, tt.actions) {
+				t.Errorf("actions = %q, want %q", actions, tt.actions)
+			}
+
+			result := make(files)
+			for rel, s := range p.result {
+				if p.failed[rel] {
+					continue
+				}
+				content, _ := hex.DecodeString(s)
+				result[rel] = string(content)
+			}
+			if !reflect.DeepEqual(result, tt.result) {
+				t.Errorf("result = %v, want %v", result, tt.result)
+			}
+		})
+	}
+}
+
+// info is a file of the size and modification time
+type info struct {
+	os.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (fi info) Size() int64        { return fi.size }
+func (fi info) ModTime() time.Time { return fi.modTime }
+
+func TestStateSides(t *testing.T) {
+	t1, t2 := time.Unix(1500000000, 0), time.Unix(1600000000, 0)
+	a, b := newSide("A", nil), newSide("B", nil)
+	b.b = true
+	st := &state{Files: map[string]*stateFile{
+		"x": {SHA256: sum("1"), This: stamp{Size: 1, ModTime: t1}, Other: stamp{Size: 1, ModTime: t2}},
+	}}
+
+	// each side is unchanged by its own file, also by the state kept by b
+	for _, st := range []*state{st, st.swapped().swapped()} {
+		if s, ok := st.sum("x", a, info{size: 1, modTime: t1}); !ok || s != sum("1") {
+			t.Errorf("sum of A = %s, %v", s, ok)
+		}
+		if _, ok := st.sum("x", a, info{size: 1, modTime: t2}); ok {
+			t.Errorf("A unchanged by the time of B")
+		}
+		if _, ok := st.sum("x", b, info{size: 1, modTime: t2}); !ok {
+			t.Errorf("B changed by its own time")
+		}
+	}
+	if kept := st.swapped().Files["x"]; !kept.This.ModTime.Equal(t2) || !kept.Other.ModTime.Equal(t1) {
+		t.Errorf("state kept by B = %+v", kept)
+	}
+}
@@ -0,0 +1,164 @@
+package backup
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+	"github.com/enjoypi/bkpic/store"
+)
+
+const (
+	outcomeStored    report.Outcome = "stored"
+	outcomeDuplicate report.Outcome = "skipped-duplicate"
+	outcomeInvalid   report.Outcome = "skipped-invalid"
+)
+
+type Config struct {
+	DryRun bool `mapstructure:"dry-run"`
+	Output string
+	Report string
+}
+
+// Run stores the media of inputs in the store of output, once for the same content,
+// and records their paths in a new snapshot.
+func Run(c *Config, inputs []string) error {
+	st, err := store.Open(c.Output)
+	if err != nil {
+		return err
+	}
+
+	progress.Start("backup")
+	defer progress.Stop()
+
+	res := report.New("backup", outcomeStored, outcomeDuplicate, outcomeInvalid)
+	snap := store.NewSnapshot(nil)
+	// the objects stored by this run
+	stored := make(map[string]bool)
+	for _, in := range inputs {
+		idx, err := index.NewIndex(in)
+		if err != nil {
+			zap.L().Info("invalid input directory", zap.String("input", in), zap.Error(err))
+			res.Fail(in, err.Error())
+			continue
+		}
+		if err := idx.LoadMeta(); err != nil {
+			zap.L().Info("invalid input directory", zap.String("input", in), zap.Error(err))
+			res.Fail(in, err.Error())
+			continue
+		}
+		snap.Inputs = append(snap.Inputs, idx.Directory())
+
+		var media index.Media
+		var bytes int64
+		for size, m := range idx.GetMediaBySize() {
+			media = append(media, m...)
+			bytes += size * int64(len(m))
+		}
+		sort.Slice(media, func(i, j int) bool { return media[i].FullPath < media[j].FullPath })
+		progress.AddTotal(int64(len(media)), bytes)
+
+		for _, m := range media {
+			if inStore(st, m) {
+				continue
+			}
+			outcome, err := backupFile(c, st, m, stored)
+			if err != nil {
+				zap.L().Info("failed to back up", zap.String("file", m.FullPath), zap.Error(err))
+				res.Fail(m.FullPath, err.Error())
+			} else {
+				res.Add(outcome, 1)
+				if outcome != outcomeInvalid {
+					snap.Files = append(snap.Files, newEntry(m))
+				}
+			}
+			progress.Add(progress.Processed, 1, m.FileInfo.Size())
+		}
+	}
+
+	if !c.DryRun {
+		if err := st.SaveSnapshot(snap); err != nil {
+			return err
+		}
+	}
+	progress.Stop()
+
+	res.Finish()
+	fmt.Printf("snapshot %s: %d files\n", snap.ID, len(snap.Files))
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if c.Report != "" {
+		if err := res.WriteJSON(c.Report); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
+
+// inStore reports whether m is a file of the store, when the store is in an input
+func inStore(st *store.Store, m *index.Medium) bool {
+	if !fs.SameStorage(st.Storage(), m.Storage()) {
+		return false
+	}
+	root := strings.TrimSuffix(st.Root(), string(os.PathSeparator))
+	return strings.HasPrefix(m.FullPath, root+string(os.PathSeparator)) || strings.HasPrefix(m.FullPath, root+"/")
+}
+
+func backupFile(c *Config, st *store.Store, m *index.Medium, stored map[string]bool) (report.Outcome, error) {
+	if !m.Valid() {
+		return outcomeInvalid, nil
+	}
+
+	m.SumSHA256()
+	if len(m.SHA256) == 0 {
+		return "", fmt.Errorf("failed to checksum")
+	}
+	sum := hex.EncodeToString(m.SHA256)
+	if stored[sum] {
+		return outcomeDuplicate, nil
+	}
+
+	ok, err := st.Has(m.SHA256)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		stored[sum] = true
+		return outcomeDuplicate, nil
+	}
+
+	if !c.DryRun {
+		if err := st.Put(m.Storage(), m.FullPath, m.SHA256); err != nil {
+			return "", err
+		}
+		progress.Add(progress.Copied, 1, m.FileInfo.Size())
+	}
+	stored[sum] = true
+	return outcomeStored, nil
+}
+
+func newEntry(m *index.Medium) store.Entry {
+	e := store.Entry{
+		Path:    m.FullPath,
+		SHA256:  hex.EncodeToString(m.SHA256),
+		Size:    m.FileInfo.Size(),
+		ModTime: m.FileInfo.ModTime(),
+	}
+	if shooting := m.ShootingTime(); shooting.Valid() {
+		t := shooting.Time
+		e.Shooting = &t
+	}
+	if meta := m.Meta(); meta != nil {
+		e.Model = strings.TrimSpace(meta.Model)
+	}
+	return e
+}
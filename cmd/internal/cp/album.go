@@ -0,0 +1,72 @@
+package cp
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/enjoypi/bkpic/fs"
+)
+
+var (
+	// usefulPattern matches the names with letters of any script, other names are numbers or symbols
+	usefulPattern = regexp.MustCompile(`\p{L}`)
+	// datePattern matches the names of dates only, like 2013, 2013-05, 201305 and 2013年5月, the layout has the date
+	datePattern = regexp.MustCompile(`^[\d\s._\-年月日]+$`)
+	// cameraPattern matches the directories created by cameras and phones, like DCIM, 100CANON and Camera
+	cameraPattern = regexp.MustCompile(`(?i)^(dcim|\d{3}[a-z0-9_]{5}|camera|camera roll|pictures|photos|images|videos|screenshots|misc)$`)
+	// genericDirs are the generic directory names by default, the dates and the camera ones are matched by the patterns
+	genericDirs = []string{
+		"照片", "相片", "图片", "视频", "个人",
+		"手机照片", "手机照相", "手机视频", "本机照片", "家庭照片", "扫描照片", "老照片",
+		"新建文件夹", "新建公文包", "导出", "备份",
+		"New Folder", "Untitled Folder", "Export", "Backup", "Import",
+	}
+)
+
+type AlbumConfig struct {
+	// Ignored are the generic directory names which never name an album, genericDirs if empty
+	Ignored []string
+
+	ignored map[string]bool
+}
+
+func (c *AlbumConfig) init() {
+	dirs := c.Ignored
+	if len(dirs) <= 0 {
+		dirs = genericDirs
+	}
+
+	// the names are matched as the directories are, sanitized and in lower case
+	c.ignored = make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		c.ignored[strings.ToLower(sanitizeName(dir))] = true
+	}
+}
+
+// useful reports whether dir is meaningful as the name of an album
+func (c *AlbumConfig) useful(dir string) bool {
+	if dir == "" || c.ignored[strings.ToLower(dir)] {
+		return false
+	}
+	if datePattern.MatchString(dir) || cameraPattern.MatchString(dir) {
+		return false
+	}
+	return usefulPattern.MatchString(dir)
+}
+
+// albumName returns the nearest meaningful directory of path under inDir in s
+func albumName(c *AlbumConfig, s fs.Storage, inDir string, path string) string {
+	dir, _ := fs.Split(s, path)
+	rel, err := fs.Rel(s, inDir, dir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+
+	dirs := strings.Split(rel, "/")
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dir := sanitizeName(dirs[i]); c.useful(dir) {
+			return dir
+		}
+	}
+	return ""
+}
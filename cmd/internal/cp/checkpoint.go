@@ -0,0 +1,307 @@
+package cp
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+const (
+	checkpointFile = "cp.checkpoint"
+	// outputFile keeps the files of the output when the run began, so a resumed run does not list it again
+	outputFile = "cp.output"
+	// eventsFile keeps the names of the {event} of the input files, so a resumed run files the rest of an event the same
+	eventsFile = "cp.events"
+)
+
+// record is one line of the checkpoint, the outcome of an input file
+type record struct {
+	Input   string         `json:"input"`
+	Path    string         `json:"path"`
+	Outcome report.Outcome `json:"outcome"`
+	Target  string         `json:"target,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	// SHA256 is of the written target
+	SHA256 string `json:"sha256,omitempty"`
+	// Source and Confidence are of the shooting time
+	Source     string `json:"source,omitempty"`
+	Confidence string `json:"confidence,omitempty"`
+}
+
+// checkpoint appends the outcome of every processed file to a file in the output directory,
+// so that an interrupted run can be resumed without handling the same files again.
+type checkpoint struct {
+	path    string
+	file    *os.File
+	encoder *json.Encoder
+	records map[string]*record
+	// names are the {event} names of the input files, loaded once by events
+	names map[string]string
+}
+
+// remoteCheckpointDir is the local directory for the checkpoint of a remote output
+func remoteCheckpointDir(location string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(location))
+	return filepath.Join(cache, "bkpic", hex.EncodeToString(sum[:8])), nil
+}
+
+func openCheckpoint(output string, resume bool, dryRun bool) (*checkpoint, error) {
+	ck := &checkpoint{
+		path:    filepath.Join(output, index.MetaDir, checkpointFile),
+		records: make(map[string]*record),
+	}
+
+	if resume {
+		if err := ck.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return ck, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ck.path), os.FileMode(0700)); err != nil {
+		return nil, err
+	}
+
+	// rewrite the loaded records to drop the truncated line
+	file, err := os.OpenFile(ck.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return nil, err
+	}
+	ck.file = file
+	ck.encoder = json.NewEncoder(file)
+	for _, r := range ck.records {
+		if err := ck.encoder.Encode(r); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	return ck, nil
+}
+
+func (ck *checkpoint) load() error {
+	file, err := os.Open(ck.path)
+	if os.IsNotExist(err) {
+		zap.L().Info("no checkpoint to resume", zap.String("checkpoint", ck.path))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r record
+		// the last line may be truncated by the interruption
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			zap.L().Debug("invalid checkpoint record", zap.Error(err), zap.ByteString("record", scanner.Bytes()))
+			continue
+		}
+		ck.records[r.Path] = &r
+	}
+
+	zap.L().Info("resume from checkpoint", zap.String("checkpoint", ck.path), zap.Int("records", len(ck.records)))
+	return scanner.Err()
+}
+
+// done reports whether path was handled by a previous run, failed files are tried again
+func (ck *checkpoint) done(path string) bool {
+	r, ok := ck.records[path]
+	return ok && r.Outcome != report.Failed
+}
+
+func (ck *checkpoint) add(r *record) {
+	ck.records[r.Path] = r
+	if ck.encoder == nil {
+		return
+	}
+
+	if err := ck.encoder.Encode(r); err != nil {
+		zap.L().Info("failed to write checkpoint", zap.Error(err), zap.String("checkpoint", ck.path))
+	}
+}
+
+// count returns the number of outcomes of input, including the ones of previous runs
+func (ck *checkpoint) count(input string) map[report.Outcome]int {
+	counts := make(map[report.Outcome]int)
+	for _, r := range ck.records {
+		if r.Input == input {
+			counts[r.Outcome]++
+		}
+	}
+	return counts
+}
+
+// close removes the checkpoint when all inputs are finished, otherwise keeps it to resume
+func (ck *checkpoint) close(finished bool) error {
+	if ck.file == nil {
+		return nil
+	}
+
+	if err := ck.file.Close(); err != nil {
+		return err
+	}
+
+	if finished {
+		for _, path := range []string{ck.outputPath(), ck.eventsPath()} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return os.Remove(ck.path)
+	}
+	return nil
+}
+
+func (ck *checkpoint) outputPath() string {
+	return filepath.Join(filepath.Dir(ck.path), outputFile)
+}
+
+// outputIndex indexes output. A resumed run takes the files of the output when the first run began
+// and the targets written since, so the output is not listed and hashed again, it is expected unchanged by others.
+func (ck *checkpoint) outputIndex(s fs.Storage, output string, resume bool) (*index.Index, error) {
+	if resume {
+		files, err := ck.loadOutput()
+		if err == nil {
+			idx := index.NewEntriesIndex(s, output, files)
+			for _, r := range ck.records {
+				if !written(r.Outcome) || r.Target == "" || idx.Get(r.Target) != nil {
+					continue
+				}
+				idx.Add(r.Target)
+				if m := idx.Get(r.Target); m != nil {
+					m.SHA256, _ = hex.DecodeString(r.SHA256)
+				}
+			}
+			zap.L().Info("resume the output", zap.String("output", output), zap.Int("files", len(files)))
+			return idx, nil
+		}
+		if !os.IsNotExist(err) {
+			zap.L().Info("invalid output of checkpoint", zap.String("checkpoint", ck.outputPath()), zap.Error(err))
+		}
+	}
+
+	idx, err := index.NewIndexIn(s, output)
+	if err != nil {
+		return nil, err
+	}
+	if ck.file == nil {
+		return idx, nil
+	}
+	if err := ck.saveOutput(idx); err != nil {
+		zap.L().Info("failed to write checkpoint", zap.String("checkpoint", ck.outputPath()), zap.Error(err))
+	}
+	return idx, nil
+}
+
+func (ck *checkpoint) loadOutput() (map[string]*index.ManifestEntry, error) {
+	file, err := os.Open(ck.outputPath())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var files map[string]*index.ManifestEntry
+	if err := json.NewDecoder(file).Decode(&files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (ck *checkpoint) saveOutput(idx *index.Index) error {
+	files, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(ck.outputPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(files); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (ck *checkpoint) eventsPath() string {
+	return filepath.Join(filepath.Dir(ck.path), eventsFile)
+}
+
+// events names the {event} of the media of idx. The names given by the interrupted run are kept,
+// the media left of an event would be clustered and named alone otherwise.
+func (ck *checkpoint) events(c *TidyConfig, idx *index.Index) map[string]string {
+	if ck.names == nil {
+		ck.names = make(map[string]string)
+		if c.Resume {
+			names, err := ck.loadEvents()
+			if err == nil && names != nil {
+				ck.names = names
+			} else if err != nil && !os.IsNotExist(err) {
+				zap.L().Info("invalid events of checkpoint", zap.String("checkpoint", ck.eventsPath()), zap.Error(err))
+			}
+		}
+	}
+
+	events := clusterEvents(&c.Event, &c.Album, idx)
+	added := false
+	for path, name := range events {
+		if old, ok := ck.names[path]; ok {
+			events[path] = old
+			continue
+		}
+		ck.names[path] = name
+		added = true
+	}
+
+	if ck.file == nil || !added {
+		return events
+	}
+	if err := ck.saveEvents(); err != nil {
+		zap.L().Info("failed to write checkpoint", zap.String("checkpoint", ck.eventsPath()), zap.Error(err))
+	}
+	return events
+}
+
+func (ck *checkpoint) loadEvents() (map[string]string, error) {
+	file, err := os.Open(ck.eventsPath())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events map[string]string
+	if err := json.NewDecoder(file).Decode(&events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (ck *checkpoint) saveEvents() error {
+	file, err := os.OpenFile(ck.eventsPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(ck.names); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,29 @@
+package cp
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/enjoypi/bkpic/index"
+)
+
+type ConfidenceConfig struct {
+	// Min is the lowest confidence of shooting times to file media by date: low, medium or high
+	Min string
+	// Review is the directory in output for the media below Min, keeping their source-relative paths,
+	// they are skipped if it is empty
+	Review string
+
+	min index.Confidence
+}
+
+func (c *ConfidenceConfig) check() error {
+	var err error
+	if c.min, err = index.ParseConfidence(c.Min); err != nil {
+		return err
+	}
+	if c.Review != "" && filepath.IsAbs(c.Review) {
+		return fmt.Errorf("invalid review directory %q, it must be relative to output", c.Review)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+// placeholders of the conflict pattern
+const (
+	placeholderName = "{name}"
+	placeholderN    = "{n}"
+	placeholderExt  = "{ext}"
+)
+
+type ConflictConfig struct {
+	// Pattern names the file when the target exists with different content,
+	// {name} is the target name without extension, {n} counts from 1 and {ext} is the extension with dot.
+	Pattern string
+}
+
+func (c *ConflictConfig) check() error {
+	if !strings.Contains(c.Pattern, placeholderN) {
+		return fmt.Errorf("invalid conflict pattern %q, it must contain %s", c.Pattern, placeholderN)
+	}
+	if strings.ContainsAny(c.Pattern, `/\`) {
+		return fmt.Errorf("invalid conflict pattern %q, it must not contain path separators", c.Pattern)
+	}
+	return nil
+}
+
+// resolveTarget returns out if it does not exist, or the existing file if it has the same content as src,
+// otherwise the first name by pattern that does not exist.
+func resolveTarget(s fs.Storage, pattern string, src *index.Medium, out string) (string, *index.Medium, error) {
+	dir, base := fs.Split(s, out)
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+
+	target := out
+	for n := 1; ; n++ {
+		_, err := s.Stat(target)
+		if os.IsNotExist(err) {
+			return target, nil, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		if existing := index.NewStorageMedium(s, target); existing != nil && existing.Identical(src) {
+			return target, existing, nil
+		}
+
+		r := strings.NewReplacer(placeholderName, name, placeholderN, strconv.Itoa(n), placeholderExt, ext)
+		target = fs.Join(s, dir, r.Replace(pattern))
+	}
+}
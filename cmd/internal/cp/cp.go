@@ -1,110 +1,178 @@
 package cp
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
-	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/enjoypi/bkpic/cmd/internal/report"
 	"github.com/enjoypi/bkpic/fs"
 	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/parity"
+	"github.com/enjoypi/bkpic/progress"
 )
 
 type TidyConfig struct {
 	DryRun bool `mapstructure:"dry-run"`
 	Move   bool
 	Output string
+	Layout string
+	Report string
+	Resume bool
+	// Moves prints the files moved within output since they were written
+	Moves bool
+	// Watch imports the new files of the inputs once their sizes are unchanged for Stable, until interrupted
+	Watch      bool
+	Stable     time.Duration
+	NoDate     NoDateConfig     `mapstructure:"nodate"`
+	Conflict   ConflictConfig   `mapstructure:"conflict"`
+	Confidence ConfidenceConfig `mapstructure:"confidence"`
+	Event      EventConfig
+	Album      AlbumConfig
+	Parity     parity.Config
 }
 
-var (
-	usefulPattern, _ = regexp.Compile(`[^\w\s\.\:\/\-]+`)
-	uselessDirs      = map[string]bool{
-		"照片":             true,
-		"手机照相":           true,
-		"个人":             true,
-		"视频":             true,
-		"贝贝":             true,
-		"妈妈爸爸":           true,
-		"贝贝手机视频":         true,
-		"手机9月5日导出":       true,
-		"扫描照片":           true,
-		"照片1":            true,
-		"妈妈手机照片":         true,
-		"来自：huawei a199": true,
-		"爷爷手机导出":         true,
-		"爸爸手机照片":         true,
-		"7-8月":           true,
-		"2013春节":         true,
-		"照片2":            true,
-		"新建文件夹":          true,
-		"2013国庆":         true,
-		"新建公文包":          true,
-		"2014春节":         true,
-		"14年":            true,
-		"ww手机照片":         true,
-		"本机照片":           true,
-		"8月":             true,
-		"9月":             true,
-		"家庭照片":           true,
-		"姜微":             true,
-		"成都照片":           true,
-		"姜蔚":             true,
-		"贝贝照片":           true,
-		"老照片":            true,
-		"2009春节":         true,
-		"2009年5月至7月照片":   true,
-		"姜":              true,
-		"2011.8贝贝":       true,
-		"小年2013-05":      true,
-		"2015年09月":       true,
-		"2015年08月":       true,
-		"2016年02月":       true,
-		"蔚家":             true,
-		"咱家":             true,
-		"08年":            true,
-		"09年":            true,
-		"10年":            true,
-		"11年":            true,
-	}
-)
-
 func Run(c *TidyConfig, inputs []string) error {
-	absOutput, err := filepath.Abs(c.Output)
+	storage, output, err := fs.Parse(c.Output)
 	if err != nil {
 		return err
 	}
 
-	if err := checkOutput(absOutput); err != nil {
+	ckDir := output
+	if fs.IsLocal(storage) {
+		if output, err = filepath.Abs(output); err != nil {
+			return err
+		}
+		if err := checkOutput(output); err != nil {
+			return err
+		}
+		c.Output, ckDir = output, output
+	} else if ckDir, err = remoteCheckpointDir(c.Output); err != nil {
 		return err
 	}
-	c.Output = absOutput
 
-	outIdx, err := index.NewIndex(absOutput)
+	if err := checkLayout(c.Layout); err != nil {
+		return err
+	}
+	if err := c.NoDate.check(); err != nil {
+		return err
+	}
+	if err := c.Conflict.check(); err != nil {
+		return err
+	}
+	if err := c.Confidence.check(); err != nil {
+		return err
+	}
+	c.Album.init()
+
+	// the files written during the first pass are imported by the watch too
+	var w *watcher
+	if c.Watch {
+		if w, err = newWatcher(c, inputs, output); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+
+	ck, err := openCheckpoint(ckDir, c.Resume, c.DryRun)
+	if err != nil {
+		return err
+	}
+
+	progress.Start("cp")
+	defer progress.Stop()
+
+	outIdx, err := ck.outputIndex(storage, output, c.Resume)
 	if err != nil {
+		_ = ck.close(false)
 		return err
 	}
 
+	res := newResult()
+	if err := trackMoves(c, storage, outIdx, res); err != nil {
+		zap.L().Info("failed to track moves", zap.String("output", c.Output), zap.Error(err))
+	}
+
+	finished := true
 	for _, in := range inputs {
-		inIdx, err := index.NewIndex(in)
-		if err != nil {
-			zap.L().Info("invalid input directory", zap.String("input", in), zap.Error(err))
-			continue
+		if err := tidyInput(c, ck, in, outIdx); err != nil {
+			res.Fail(in, err.Error())
+			finished = false
 		}
+	}
 
-		if err := inIdx.LoadMeta(); err != nil {
-			zap.L().Info("invalid input directory", zap.String("input", in), zap.Error(err))
-			continue
+	if !c.DryRun {
+		if err := updateManifest(storage, output, ck.records); err != nil {
+			zap.L().Info("failed to update manifest", zap.String("output", c.Output), zap.Error(err))
+		}
+		if c.Parity.Redundancy > 0 {
+			updateParity(storage, ck.records, &c.Parity)
 		}
+	}
 
-		if err := doTidy(c, inIdx, outIdx); err != nil {
-			zap.L().Info("failed to tidy", zap.String("input", in), zap.Error(err))
+	if w != nil {
+		w.run(c, ck, outIdx)
+	}
+	// the files are handled already, the report is written even if the checkpoint is not closed
+	closeErr := ck.close(finished)
+	if closeErr != nil {
+		zap.L().Info("failed to close checkpoint", zap.String("output", c.Output), zap.Error(closeErr))
+	}
+	progress.Stop()
+
+	addRecords(res, ck)
+	res.Finish()
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if c.Report != "" {
+		if err := res.WriteJSON(c.Report); err != nil {
+			return err
 		}
 	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return res.Err()
+}
+
+// tidyInput tidies the files of input into the output, an archive is closed after
+func tidyInput(c *TidyConfig, ck *checkpoint, in string, outIdx *index.Index) error {
+	// the files done by the previous runs are not indexed again
+	inIdx, err := index.NewIndexExcept(in, ck.done)
+	if err != nil {
+		zap.L().Info("invalid input directory", zap.String("input", in), zap.Error(err))
+		return err
+	}
+	defer fs.Close(inIdx.Storage())
+
+	if _, ok := inIdx.Storage().(*fs.Archive); ok && c.Move {
+		zap.L().Info("invalid input directory", zap.String("input", in), zap.String("error", "move from archive"))
+		return errors.New("cannot move from a read-only archive")
+	}
+
+	if err := inIdx.LoadMeta(); err != nil {
+		zap.L().Info("invalid input directory", zap.String("input", in), zap.Error(err))
+		return err
+	}
+
+	var files, bytes int64
+	for size, media := range inIdx.GetMediaBySize() {
+		files += int64(len(media))
+		bytes += size * int64(len(media))
+	}
+	progress.AddTotal(files, bytes)
+
+	if err := doTidy(c, ck, inIdx, outIdx); err != nil {
+		zap.L().Info("failed to tidy", zap.String("input", in), zap.Error(err))
+		return err
+	}
 	return nil
 }
 
@@ -129,14 +197,19 @@ func checkOutput(output string) error {
 	return nil
 }
 
-func doTidy(c *TidyConfig, inIdx *index.Index, outIdx *index.Index) error {
+func doTidy(c *TidyConfig, ck *checkpoint, inIdx *index.Index, outIdx *index.Index) error {
 	inDir := inIdx.Directory()
 	outRootDir := outIdx.Directory()
-	if inDir == outRootDir {
+	if inDir == outRootDir && fs.SameStorage(inIdx.Storage(), outIdx.Storage()) {
 		return fmt.Errorf("input is same with output %s", inDir)
 	}
 
-	var count int
+	var events map[string]string
+	if strings.Contains(c.Layout, placeholderEvent) {
+		events = ck.events(c, inIdx)
+	}
+
+	var resumed int
 	walk := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -146,94 +219,155 @@ func doTidy(c *TidyConfig, inIdx *index.Index, outIdx *index.Index) error {
 			return nil
 		}
 
-		src := inIdx.Get(path)
-		if src == nil || !src.Valid() {
-			zap.L().Info("invalid medium", zap.String("file", path))
+		if ck.done(path) {
+			resumed++
 			return nil
 		}
 
-		same := outIdx.Same(src)
-		if same != nil {
-			count++
-			zap.L().Debug("file already exists", zap.String("source", path), zap.String("same", same.FullPath))
-			return nil
+		tidyPath(c, ck, events, path, inIdx, outIdx)
+		// the total is of the indexed media, the other files are counted when met, so it is never passed
+		if m := inIdx.Get(path); m != nil {
+			progress.Add(progress.Processed, 1, m.FileInfo.Size())
+		} else {
+			progress.AddTotal(1, 0)
+			progress.Add(progress.Processed, 1, 0)
 		}
+		return nil
+	}
 
-		out, outDir := genOutPath(src, outRootDir)
-		if out == path {
-			return nil
+	if err := fs.Walk(inIdx.Storage(), inDir, walk); err != nil {
+		return err
+	}
+
+	counts := ck.count(inDir)
+	zap.S().Infof("已完成。总文件：%d，成功：%d", inIdx.Size()+resumed,
+		counts[outcomeCopied]+counts[outcomeMoved]+counts[outcomeRenamed]+counts[outcomeDuplicate])
+	return nil
+}
+
+// tidyPath tidies the file of inIdx at path and records its outcome in the checkpoint
+func tidyPath(c *TidyConfig, ck *checkpoint, events map[string]string, path string, inIdx *index.Index, outIdx *index.Index) {
+	var err error
+	r := &record{Input: inIdx.Directory(), Path: path}
+	r.Outcome, r.Target, err = tidyFile(c, events, path, inIdx, outIdx)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if src := inIdx.Get(path); src != nil && src.Valid() {
+		if written(r.Outcome) && len(src.SHA256) > 0 {
+			r.SHA256 = hex.EncodeToString(src.SHA256)
 		}
+		if shooting := src.ShootingTime(); shooting.Valid() {
+			r.Source = string(shooting.Source)
+			r.Confidence = shooting.Confidence().String()
+		}
+	}
+	ck.add(r)
+}
 
-		for i := 1; i <= 9; i++ {
-			_, err := os.Stat(out)
-			if os.IsNotExist(err) {
-				// do nothing
-				if c.DryRun {
-					break
-				}
-				if err := os.MkdirAll(outDir, os.FileMode(0700)); err != nil {
-					zap.L().Info("failed to make directory", zap.Error(err), zap.String("directory", outDir))
-					return nil
-				}
-				if c.Move {
-					if err := syscall.Rename(path, out); err != nil {
-						zap.L().Info("failed to move file", zap.Error(err), zap.String("source", path), zap.String("target", out))
-						return nil
-					}
-				} else {
-					if err := fs.Copy(path, out); err != nil {
-						zap.L().Info("failed to copy file", zap.Error(err), zap.String("source", path), zap.String("target", out))
-						return nil
-					}
-				}
-				outIdx.Add(out)
-			} else if os.IsExist(err) {
-				ext := filepath.Ext(out)
-				out = strings.TrimSuffix(out, ext) + fmt.Sprintf("_%d", i) + ext
-				continue
-			} else if err != nil {
-				zap.L().Info("failed to get file info", zap.Error(err), zap.String("file", out))
-				return nil
-			}
+func tidyFile(c *TidyConfig, events map[string]string, path string, inIdx *index.Index, outIdx *index.Index) (report.Outcome, string, error) {
+	src := inIdx.Get(path)
+	if src == nil || !src.Valid() {
+		zap.L().Info("invalid medium", zap.String("file", path))
+		return outcomeInvalid, "", nil
+	}
+
+	same := outIdx.Same(src)
+	if same != nil {
+		zap.L().Debug("file already exists", zap.String("source", path), zap.String("same", same.FullPath))
+		return outcomeDuplicate, same.FullPath, nil
+	}
+
+	unsorted, review := false, false
+	l := labels{event: events[path], album: albumName(&c.Album, inIdx.Storage(), inIdx.Directory(), path)}
+	out := genOutPath(outIdx.Storage(), c.Layout, src, l, outIdx.Directory())
+	if shooting := src.ShootingTime(); out != "" && shooting.Confidence() < c.Confidence.min {
+		if c.Confidence.Review == "" {
+			zap.L().Info("low confidence of shooting time", zap.String("file", path),
+				zap.String("source", string(shooting.Source)), zap.Stringer("confidence", shooting.Confidence()))
+			return outcomeLowConf, "", nil
 		}
 
-		zap.L().Info(fmt.Sprintf("%s\t=>\t%s", path, out))
-		count++
-		return nil
+		var err error
+		out, err = genRelPath(outIdx.Storage(), c.Confidence.Review, src, inIdx.Directory(), outIdx.Directory())
+		if err != nil {
+			return report.Failed, "", err
+		}
+		review = true
+	}
+	if out == "" {
+		var err error
+		out, err = genNoDatePath(&c.NoDate, outIdx.Storage(), c.Layout, src, l, inIdx.Directory(), outIdx.Directory())
+		if err != nil {
+			return report.Failed, "", err
+		}
+		if out == "" {
+			zap.L().Info("no shooting time", zap.String("file", path))
+			return outcomeNoDate, "", nil
+		}
+		unsorted = c.NoDate.Policy == noDateUnsorted
+	}
+	if out == path {
+		return outcomeDuplicate, out, nil
 	}
 
-	if err := filepath.Walk(inDir, walk); err != nil {
-		return err
+	target, existing, err := resolveTarget(outIdx.Storage(), c.Conflict.Pattern, src, out)
+	if err != nil {
+		zap.L().Info("failed to resolve target", zap.Error(err), zap.String("source", path), zap.String("target", out))
+		return report.Failed, out, err
 	}
+	if existing != nil {
+		zap.L().Debug("file already exists", zap.String("source", path), zap.String("same", existing.FullPath))
+		return outcomeDuplicate, existing.FullPath, nil
+	}
+	renamed := target != out
+	out = target
 
-	zap.S().Infof("已完成。总文件：%d，成功：%d", inIdx.Size(), count)
-	return nil
+	if !c.DryRun {
+		// the checksum of the source is kept in the manifest of output
+		src.SumSHA256()
+		if c.Move {
+			if err := fs.MoveFile(inIdx.Storage(), path, outIdx.Storage(), out); err != nil {
+				zap.L().Info("failed to move file", zap.Error(err), zap.String("source", path), zap.String("target", out))
+				return report.Failed, out, err
+			}
+		} else {
+			if err := fs.CopyFile(inIdx.Storage(), path, outIdx.Storage(), out); err != nil {
+				zap.L().Info("failed to copy file", zap.Error(err), zap.String("source", path), zap.String("target", out))
+				return report.Failed, out, err
+			}
+		}
+		outIdx.Add(out)
+		if added := outIdx.Get(out); added != nil {
+			added.SHA256 = src.SHA256
+		}
+		progress.Add(progress.Copied, 1, src.FileInfo.Size())
+	}
+
+	zap.L().Info(fmt.Sprintf("%s\t=>\t%s", path, out))
+	switch {
+	case renamed:
+		return outcomeRenamed, out, nil
+	case unsorted:
+		return outcomeUnsorted, out, nil
+	case review:
+		return outcomeReview, out, nil
+	case c.Move:
+		return outcomeMoved, out, nil
+	default:
+		return outcomeCopied, out, nil
+	}
+}
+
+func genOutPath(s fs.Storage, layout string, src *index.Medium, l labels, absTgt string) string {
+	shooting := src.ShootingTime()
+	if !shooting.Valid() {
+		return ""
+	}
+	return genDatePath(s, layout, shooting.Time, src, l, absTgt)
 }
 
-func genOutPath(src *index.Medium, absTgt string) (string, string) {
-	if src.ShootingTime() <= 0 {
-		return "", ""
-	}
-
-	shooting := time.Unix(src.ShootingTime(), 0)
-	tgtDir := fmt.Sprintf("%04d/%02d", shooting.Year(), shooting.Month())
-
-	// match han
-	//dirs := strings.Split(src.RelativePath, "/")
-	//n := len(dirs)
-	//if n > 0 {
-	//	for i := n - 1; i >= 0; i-- {
-	//		dir := dirs[i]
-	//		if ok := uselessDirs[strings.ToLower(dir)]; ok {
-	//			continue
-	//		}
-	//
-	//		if usefulPattern.MatchString(dir) {
-	//			tgtDir = tgtDir + "/" + dir
-	//			break
-	//		}
-	//	}
-	//}
-	tgtPath := tgtDir + "/" + src.FileInfo.Name()
-	return absTgt + "/" + tgtPath, absTgt + "/" + tgtDir
+// genDatePath buckets by the local date of shooting, absTgt is in the output storage s
+func genDatePath(s fs.Storage, layout string, shooting time.Time, src *index.Medium, l labels, absTgt string) string {
+	return fs.Join(s, absTgt, genDir(layout, shooting, src, l), src.FileInfo.Name())
 }
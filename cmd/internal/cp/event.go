@@ -0,0 +1,102 @@
+package cp
+
+import (
+	"sort"
+	"time"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/geo"
+	"github.com/enjoypi/bkpic/index"
+)
+
+type EventConfig struct {
+	// Gap between the shooting times of two media starts a new event
+	Gap time.Duration
+	// Distance in km between the places of two media starts a new event
+	Distance float64
+}
+
+// clusterEvents groups the dated media of idx into events by the gaps of shooting time and place,
+// and returns the event names by path
+func clusterEvents(c *EventConfig, album *AlbumConfig, idx *index.Index) map[string]string {
+	type shot struct {
+		medium *index.Medium
+		time   time.Time
+	}
+
+	var shots []shot
+	for _, media := range idx.GetMediaBySize() {
+		for _, m := range media {
+			if !m.Valid() {
+				continue
+			}
+			if shooting := m.ShootingTime(); shooting.Valid() {
+				shots = append(shots, shot{medium: m, time: shooting.Time})
+			}
+		}
+	}
+	sort.Slice(shots, func(i, j int) bool {
+		if shots[i].time.Equal(shots[j].time) {
+			return shots[i].medium.FullPath < shots[j].medium.FullPath
+		}
+		return shots[i].time.Before(shots[j].time)
+	})
+
+	events := make(map[string]string, len(shots))
+	var event []*index.Medium
+	var last time.Time
+	var lastPlace *geo.Coordinates
+	for _, s := range shots {
+		split := len(event) > 0 && c.Gap > 0 && s.time.Sub(last) > c.Gap
+		coordinates, ok := s.medium.GPS()
+		if ok {
+			if lastPlace != nil && c.Distance > 0 && lastPlace.Distance(coordinates) > c.Distance {
+				split = true
+			}
+		}
+
+		if split {
+			nameEvent(album, idx.Storage(), idx.Directory(), event, events)
+			event, lastPlace = nil, nil
+		}
+		event = append(event, s.medium)
+		last = s.time
+		if ok {
+			lastPlace = &coordinates
+		}
+	}
+	nameEvent(album, idx.Storage(), idx.Directory(), event, events)
+	return events
+}
+
+// nameEvent names the media of event by their most common album, or by their most common city
+func nameEvent(album *AlbumConfig, s fs.Storage, inDir string, event []*index.Medium, events map[string]string) {
+	albums := make(map[string]int)
+	cities := make(map[string]int)
+	for _, m := range event {
+		if name := albumName(album, s, inDir, m.FullPath); name != "" {
+			albums[name]++
+		}
+		if place, ok := m.Place(); ok && place.City != "" {
+			cities[place.City]++
+		}
+	}
+
+	name := mostCommon(albums)
+	if name == "" {
+		name = mostCommon(cities)
+	}
+	for _, m := range event {
+		events[m.FullPath] = name
+	}
+}
+
+func mostCommon(counts map[string]int) string {
+	var name string
+	for k, n := range counts {
+		if n > counts[name] || (n == counts[name] && k < name) {
+			name = k
+		}
+	}
+	return name
+}
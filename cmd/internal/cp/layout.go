@@ -0,0 +1,116 @@
+package cp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/enjoypi/bkpic/index"
+)
+
+// placeholders of the layout
+const (
+	placeholderYear    = "{year}"
+	placeholderMonth   = "{month}"
+	placeholderDay     = "{day}"
+	placeholderCountry = "{country}"
+	placeholderRegion  = "{region}"
+	placeholderCity    = "{city}"
+	placeholderEvent   = "{event}"
+	placeholderAlbum   = "{album}"
+)
+
+// maxNameBytes is the longest name of most file systems
+const maxNameBytes = 255
+
+var (
+	layoutPattern      = regexp.MustCompile(`\{[a-z]+\}`)
+	layoutPlaceholders = map[string]bool{
+		placeholderYear:    true,
+		placeholderMonth:   true,
+		placeholderDay:     true,
+		placeholderCountry: true,
+		placeholderRegion:  true,
+		placeholderCity:    true,
+		placeholderEvent:   true,
+		placeholderAlbum:   true,
+	}
+	// reservedNames are the characters not allowed in names by Windows and the others
+	reservedNames = strings.NewReplacer("/", "-", `\`, "-", ":", "-", "*", "-", "?", "-", `"`, "-", "<", "-", ">", "-", "|", "-")
+)
+
+func checkLayout(layout string) error {
+	if strings.TrimSpace(layout) == "" {
+		return fmt.Errorf("empty layout")
+	}
+	for _, p := range layoutPattern.FindAllString(layout, -1) {
+		if !layoutPlaceholders[p] {
+			return fmt.Errorf("unknown placeholder %s in layout %q", p, layout)
+		}
+	}
+	return nil
+}
+
+// labels are the names of a medium in output besides its date and place
+type labels struct {
+	event string
+	album string
+}
+
+// genDir fills the placeholders of layout by the local date of shooting, the place and the labels of src,
+// the directories left empty are dropped
+func genDir(layout string, shooting time.Time, src *index.Medium, l labels) string {
+	values := []string{
+		placeholderYear, fmt.Sprintf("%04d", shooting.Year()),
+		placeholderMonth, fmt.Sprintf("%02d", shooting.Month()),
+		placeholderDay, fmt.Sprintf("%02d", shooting.Day()),
+		placeholderEvent, sanitizeName(l.event),
+		placeholderAlbum, sanitizeName(l.album),
+	}
+	if layoutPattern.MatchString(strings.NewReplacer(values...).Replace(layout)) {
+		place, _ := src.Place()
+		values = append(values,
+			placeholderCountry, sanitizeName(place.Country),
+			placeholderRegion, sanitizeName(place.Region),
+			placeholderCity, sanitizeName(place.City),
+		)
+	}
+
+	r := strings.NewReplacer(values...)
+	dirs := strings.Split(layout, "/")
+	filled := dirs[:0]
+	for _, dir := range dirs {
+		if dir = strings.TrimSpace(r.Replace(dir)); dir != "" {
+			filled = append(filled, dir)
+		}
+	}
+	return strings.Join(filled, "/")
+}
+
+// sanitizeName makes name safe as a directory: composed, without reserved and control characters,
+// spaces collapsed, no trailing dots and at most maxNameBytes
+func sanitizeName(name string) string {
+	name = norm.NFC.String(reservedNames.Replace(name))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.TrimRight(name, ". ")
+
+	for len(name) > maxNameBytes {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return strings.TrimRight(name, ". ")
+}
@@ -0,0 +1,75 @@
+package cp
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+// written reports whether a target was written by the outcome
+func written(o report.Outcome) bool {
+	switch o {
+	case outcomeCopied, outcomeMoved, outcomeRenamed, outcomeUnsorted, outcomeReview:
+		return true
+	}
+	return false
+}
+
+// updateManifest adds the written targets of records to the manifest of output, so verify finds them damaged later
+func updateManifest(s fs.Storage, output string, records map[string]*record) error {
+	mf, err := index.LoadManifest(s, output)
+	if err != nil {
+		return err
+	}
+
+	var updated bool
+	for _, r := range records {
+		if !written(r.Outcome) || r.SHA256 == "" {
+			continue
+		}
+		rel, err := fs.Rel(s, output, r.Target)
+		if err != nil {
+			return err
+		}
+		info, err := s.Stat(r.Target)
+		if err != nil {
+			continue
+		}
+		mf.Files[rel] = &index.ManifestEntry{SHA256: r.SHA256, Size: info.Size(), ModTime: info.ModTime()}
+		updated = true
+	}
+
+	if !updated {
+		return nil
+	}
+	return mf.Save()
+}
+
+// trackMoves follows the files moved within output since they were written by their contents,
+// so they are known as backed up without reading them, and their new paths are kept in the manifest.
+func trackMoves(c *TidyConfig, s fs.Storage, outIdx *index.Index, res *report.Result) error {
+	mf, err := index.LoadManifest(s, outIdx.Directory())
+	if err != nil {
+		return err
+	}
+	moves, err := mf.Identify(outIdx)
+	if err != nil {
+		return err
+	}
+
+	for _, mv := range moves {
+		zap.L().Info("moved in output", zap.String("from", mv.From), zap.String("to", mv.To))
+		res.Tally("output", "moved")
+		if c.Moves {
+			fmt.Printf("moved\t%s\t=>\t%s\n", mv.From, mv.To)
+		}
+	}
+	if len(moves) == 0 || c.DryRun {
+		return nil
+	}
+	return mf.Save()
+}
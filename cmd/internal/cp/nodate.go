@@ -0,0 +1,87 @@
+package cp
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+// policies for the media without shooting time
+const (
+	noDateUnsorted = "unsorted"
+	noDateFileDate = "filedate"
+	noDateSkip     = "skip"
+)
+
+// file dates to fall back to
+const (
+	fileDateModify = "modify"
+	fileDateCreate = "create"
+)
+
+type NoDateConfig struct {
+	// Policy is one of unsorted, filedate and skip
+	Policy string
+	// Dir is the directory in output for the unsorted media, the source-relative path is kept under it
+	Dir string
+	// FileDate is the file date used by the filedate policy, modify or create
+	FileDate string `mapstructure:"filedate"`
+}
+
+func (c *NoDateConfig) check() error {
+	switch c.Policy {
+	case noDateUnsorted:
+		if c.Dir == "" || filepath.IsAbs(c.Dir) {
+			return fmt.Errorf("invalid directory for undated media %q, it must be relative to output", c.Dir)
+		}
+	case noDateFileDate:
+		if c.FileDate != fileDateModify && c.FileDate != fileDateCreate {
+			return fmt.Errorf("invalid file date %q, expect %s or %s", c.FileDate, fileDateModify, fileDateCreate)
+		}
+	case noDateSkip:
+	default:
+		return fmt.Errorf("invalid policy for undated media %q, expect %s, %s or %s",
+			c.Policy, noDateUnsorted, noDateFileDate, noDateSkip)
+	}
+	return nil
+}
+
+// genNoDatePath returns the output path of src without shooting time by the policy,
+// or an empty string if src should be skipped.
+func genNoDatePath(c *NoDateConfig, s fs.Storage, layout string, src *index.Medium, l labels, inDir string, absTgt string) (string, error) {
+	switch c.Policy {
+	case noDateUnsorted:
+		return genRelPath(s, c.Dir, src, inDir, absTgt)
+
+	case noDateFileDate:
+		var date time.Time
+		if meta := src.Meta(); meta != nil {
+			value := meta.FileModifyDate
+			if c.FileDate == fileDateCreate {
+				value = meta.FileCreateDate
+			}
+			date, _ = index.ParseTime(value)
+		}
+		if date.IsZero() {
+			date = src.FileInfo.ModTime()
+		}
+		if date.Unix() <= 0 {
+			return "", nil
+		}
+		return genDatePath(s, layout, date.In(index.DefaultLocation), src, l, absTgt), nil
+	}
+
+	return "", nil
+}
+
+// genRelPath keeps the source-relative path of src under dir of output in s
+func genRelPath(s fs.Storage, dir string, src *index.Medium, inDir string, absTgt string) (string, error) {
+	rel, err := fs.Rel(src.Storage(), inDir, src.FullPath)
+	if err != nil {
+		return "", err
+	}
+	return fs.Join(s, absTgt, dir, rel), nil
+}
@@ -0,0 +1,42 @@
+package cp
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/parity"
+)
+
+// updateParity regenerates the parity of the directories written by records
+func updateParity(s fs.Storage, records map[string]*record, c *parity.Config) {
+	dirs := make(map[string]bool)
+	for _, r := range records {
+		if !written(r.Outcome) {
+			continue
+		}
+		if fs.IsLocal(s) {
+			dirs[filepath.Dir(r.Target)] = true
+		} else {
+			dirs[path.Dir(r.Target)] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	for _, dir := range sorted {
+		// a directory written before by the same watch
+		if ok, err := parity.UpToDate(s, dir, c); err == nil && ok {
+			continue
+		}
+		if err := parity.Write(s, dir, c); err != nil {
+			zap.L().Info("failed to write parity", zap.String("directory", dir), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package cp
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+)
+
+const (
+	outcomeCopied    report.Outcome = "copied"
+	outcomeMoved     report.Outcome = "moved"
+	outcomeRenamed   report.Outcome = "conflict-renamed"
+	outcomeDuplicate report.Outcome = "skipped-duplicate"
+	outcomeInvalid   report.Outcome = "skipped-invalid"
+	outcomeNoDate    report.Outcome = "no-date"
+	outcomeUnsorted  report.Outcome = "no-date-unsorted"
+	outcomeReview    report.Outcome = "low-confidence-review"
+	outcomeLowConf   report.Outcome = "skipped-low-confidence"
+)
+
+func newResult() *report.Result {
+	return report.New("cp",
+		outcomeCopied,
+		outcomeMoved,
+		outcomeRenamed,
+		outcomeDuplicate,
+		outcomeInvalid,
+		outcomeNoDate,
+		outcomeUnsorted,
+		outcomeReview,
+		outcomeLowConf,
+	)
+}
+
+// addRecords counts the records of the checkpoint, which cover the resumed runs too
+func addRecords(res *report.Result, ck *checkpoint) {
+	for _, r := range ck.records {
+		if r.Outcome == report.Failed {
+			res.Fail(r.Path, r.Error)
+			continue
+		}
+		res.Add(r.Outcome, 1)
+		if r.Source != "" {
+			res.Tally("time source", r.Source)
+			res.Tally("time confidence", r.Confidence)
+		}
+	}
+}
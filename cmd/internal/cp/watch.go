@@ -0,0 +1,302 @@
+package cp
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+)
+
+// pending is a new file of an input, imported when its size and modification time stay the same for a while
+type pending struct {
+	root    string
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// watcher follows the new files of the local input directories
+type watcher struct {
+	*fsnotify.Watcher
+	roots  []string
+	output string
+	done   chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]*pending
+}
+
+// newWatcher watches the inputs at once, the files written from now on are pending until run imports them
+func newWatcher(c *TidyConfig, inputs []string, output string) (*watcher, error) {
+	if c.Stable <= 0 {
+		return nil, fmt.Errorf("invalid stable duration %s", c.Stable)
+	}
+
+	var roots []string
+	for _, in := range inputs {
+		s, dir, err := fs.Parse(in)
+		if err != nil {
+			return nil, err
+		}
+		if !fs.IsLocal(s) {
+			return nil, fmt.Errorf("cannot watch %s, only local directories are watched", in)
+		}
+		if dir, err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+		roots = append(roots, dir)
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{Watcher: fw, roots: roots, done: make(chan struct{}), pending: make(map[string]*pending)}
+	// a remote output is not written into the inputs
+	if s, _, err := fs.Parse(c.Output); err == nil && fs.IsLocal(s) {
+		w.output = output
+	}
+	for _, root := range roots {
+		if err := w.addTree(root, false); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	// the events are taken while the first pass runs, so the queue of the kernel does not overflow
+	go w.collect()
+	return w, nil
+}
+
+func (w *watcher) collect() {
+	defer close(w.done)
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			w.mu.Lock()
+			w.handle(ev)
+			w.mu.Unlock()
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			zap.L().Info("watch error", zap.Error(err))
+		}
+	}
+}
+
+func (w *watcher) Close() error {
+	err := w.Watcher.Close()
+	<-w.done
+	return err
+}
+
+// run imports the pending files once stable, until interrupted
+func (w *watcher) run(c *TidyConfig, ck *checkpoint, outIdx *index.Index) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	interval := c.Stable / 2
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	zap.L().Info("watching", zap.Strings("inputs", w.roots), zap.Duration("stable", c.Stable))
+	for {
+		select {
+		case <-sig:
+			return
+		case now := <-ticker.C:
+			w.mu.Lock()
+			ready := w.stable(now, c.Stable)
+			w.mu.Unlock()
+			for root, files := range ready {
+				importFiles(c, ck, root, files, outIdx)
+			}
+		}
+	}
+}
+
+// addTree watches dir and its subdirectories, their files are pending if found is true
+func (w *watcher) addTree(dir string, found bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// removed before being watched
+			return nil
+		}
+		if w.ignored(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return w.Add(path)
+		}
+		if found {
+			w.touch(path, info)
+		}
+		return nil
+	})
+}
+
+// ignored reports whether path is of bkpic itself or the output written by the watch
+func (w *watcher) ignored(path string) bool {
+	if w.output != "" && (path == w.output || strings.HasPrefix(path, w.output+string(filepath.Separator))) {
+		return true
+	}
+	for _, name := range strings.Split(path, string(filepath.Separator)) {
+		if name == index.MetaDir {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watcher) handle(ev fsnotify.Event) {
+	if w.ignored(ev.Name) {
+		return
+	}
+	if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		delete(w.pending, ev.Name)
+		return
+	}
+	if ev.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return
+	}
+
+	info, err := os.Stat(ev.Name)
+	if err != nil {
+		delete(w.pending, ev.Name)
+		return
+	}
+	if info.IsDir() {
+		// the files may be written before the directory is watched
+		if err := w.addTree(ev.Name, true); err != nil {
+			zap.L().Info("failed to watch directory", zap.String("directory", ev.Name), zap.Error(err))
+		}
+		return
+	}
+	w.touch(ev.Name, info)
+}
+
+// touch makes path pending from now on, unless it is unchanged
+func (w *watcher) touch(path string, info os.FileInfo) {
+	if p, ok := w.pending[path]; ok && p.size == info.Size() && p.modTime.Equal(info.ModTime()) {
+		return
+	}
+
+	root := w.rootOf(path)
+	if root == "" {
+		return
+	}
+	w.pending[path] = &pending{root: root, size: info.Size(), modTime: info.ModTime(), since: time.Now()}
+}
+
+// rootOf returns the innermost input of path
+func (w *watcher) rootOf(path string) string {
+	var root string
+	for _, r := range w.roots {
+		if strings.HasPrefix(path, r+string(filepath.Separator)) && len(r) > len(root) {
+			root = r
+		}
+	}
+	return root
+}
+
+// stable returns the pending files unchanged for d by their inputs, they are not pending any more
+func (w *watcher) stable(now time.Time, d time.Duration) map[string][]string {
+	ready := make(map[string][]string)
+	for path, p := range w.pending {
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(w.pending, path)
+			continue
+		}
+		if info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+			p.size, p.modTime, p.since = info.Size(), info.ModTime(), now
+			continue
+		}
+		if now.Sub(p.since) < d {
+			continue
+		}
+		ready[p.root] = append(ready[p.root], path)
+		delete(w.pending, path)
+	}
+	for _, files := range ready {
+		sort.Strings(files)
+	}
+	return ready
+}
+
+// importFiles tidies the new files of the input root into the output, as the first pass does
+func importFiles(c *TidyConfig, ck *checkpoint, root string, files []string, outIdx *index.Index) {
+	inIdx := index.NewFilesIndex(fs.NewLocal(), root, files)
+
+	// the files of the first pass are pending too while it runs, the unchanged ones are imported already
+	var fresh []string
+	for _, path := range files {
+		if m := inIdx.Get(path); m != nil && ck.done(path) && outIdx.Same(m) != nil {
+			inIdx.Remove(path)
+			continue
+		}
+		fresh = append(fresh, path)
+	}
+	if files = fresh; len(files) == 0 {
+		return
+	}
+
+	if err := inIdx.LoadMeta(); err != nil {
+		zap.L().Info("invalid input files", zap.String("input", root), zap.Error(err))
+		return
+	}
+
+	var bytes int64
+	for size, media := range inIdx.GetMediaBySize() {
+		bytes += size * int64(len(media))
+	}
+	progress.AddTotal(int64(len(files)), bytes)
+
+	var events map[string]string
+	if strings.Contains(c.Layout, placeholderEvent) {
+		events = clusterEvents(&c.Event, &c.Album, inIdx)
+	}
+	records := make(map[string]*record)
+	for _, path := range files {
+		tidyPath(c, ck, events, path, inIdx, outIdx)
+		records[path] = ck.records[path]
+		var size int64
+		if m := inIdx.Get(path); m != nil {
+			size = m.FileInfo.Size()
+		}
+		progress.Add(progress.Processed, 1, size)
+	}
+
+	if c.DryRun {
+		return
+	}
+	if err := updateManifest(outIdx.Storage(), outIdx.Directory(), records); err != nil {
+		zap.L().Info("failed to update manifest", zap.String("output", c.Output), zap.Error(err))
+	}
+	if c.Parity.Redundancy > 0 {
+		updateParity(outIdx.Storage(), records, &c.Parity)
+	}
+}
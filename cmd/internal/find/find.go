@@ -0,0 +1,72 @@
+package find
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/geo"
+	"github.com/enjoypi/bkpic/index"
+)
+
+type Config struct {
+	// Country matches the name or the code of country
+	Country string
+	Region  string
+	City    string
+}
+
+// Run prints the media in args which were shot at the place.
+func Run(c *Config, args []string) error {
+	var found []*index.Medium
+	for _, arg := range args {
+		idx, err := index.NewIndex(arg)
+		if err != nil {
+			return err
+		}
+		if err := idx.LoadMeta(); err != nil {
+			return err
+		}
+
+		for _, media := range idx.GetMediaBySize() {
+			for _, m := range media {
+				if !m.Valid() {
+					continue
+				}
+				if place, ok := m.Place(); ok && c.match(&place) {
+					found = append(found, m)
+				}
+			}
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].FullPath < found[j].FullPath
+	})
+	for _, m := range found {
+		place, _ := m.Place()
+		shooting := ""
+		if t := m.ShootingTime(); t.Valid() {
+			shooting = t.Format(time.RFC3339)
+		}
+		fmt.Printf("%s\t%s\t%s, %s, %s\n", m.FullPath, shooting, place.City, place.Region, place.Country)
+	}
+	zap.L().Debug("found media", zap.Int("count", len(found)))
+	return nil
+}
+
+func (c *Config) match(place *geo.Place) bool {
+	if c.Country != "" && !strings.EqualFold(c.Country, place.Country) && !strings.EqualFold(c.Country, place.CountryCode) {
+		return false
+	}
+	if c.Region != "" && !strings.EqualFold(c.Region, place.Region) {
+		return false
+	}
+	if c.City != "" && !strings.EqualFold(c.City, place.City) {
+		return false
+	}
+	return true
+}
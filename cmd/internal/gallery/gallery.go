@@ -0,0 +1,342 @@
+package gallery
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+	"github.com/enjoypi/bkpic/thumb"
+)
+
+const (
+	outcomeShown   report.Outcome = "shown"
+	outcomeNoThumb report.Outcome = "no-thumbnail"
+
+	// assetsDir keeps the thumbnails and the copied originals in the site, apart from the albums,
+	// it is numbered if the archive has an album of the name
+	assetsDir = "_gallery"
+)
+
+type Config struct {
+	Output string
+	// Cache is the directory of thumbnails, the one of the user if empty
+	Cache string
+	// Copy copies the originals into the site, otherwise they are linked relative to the site,
+	// and the links break if the site is moved without the archive, like to a USB stick
+	Copy   bool
+	Report string
+}
+
+// album is a directory of the archive, by the slash-separated path relative to the archive
+type album struct {
+	rel    string
+	albums []*album
+	items  []*item
+	// count and cover are of all the media under the album
+	count int
+	cover string
+}
+
+func (a *album) name() string {
+	if a.rel == "" {
+		return "gallery"
+	}
+	return path.Base(a.rel)
+}
+
+// item is a medium of an album, the links are relative to the root of the site
+type item struct {
+	name     string
+	shooting time.Time
+	time     string
+	model    string
+	place    string
+	video    bool
+	thumb    string
+	original string
+}
+
+// Run exports the archive at location as a static gallery of HTML pages in the output directory,
+// one page by each directory of the archive.
+func Run(c *Config, location string) error {
+	site, err := filepath.Abs(c.Output)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.NewIndex(location)
+	if err != nil {
+		return err
+	}
+	s, root := idx.Storage(), idx.Directory()
+	if fs.IsLocal(s) {
+		if site == root || strings.HasPrefix(site, root+string(filepath.Separator)) {
+			return fmt.Errorf("the site %s is in the archive %s", site, root)
+		}
+	} else if !c.Copy {
+		return fmt.Errorf("the originals of %s cannot be linked, copy them without --copy=false", location)
+	}
+	if err := os.MkdirAll(site, os.FileMode(0755)); err != nil {
+		return err
+	}
+	if err := idx.LoadMeta(); err != nil {
+		return err
+	}
+
+	cache, err := thumb.Open(c.Cache)
+	if err != nil {
+		return err
+	}
+
+	progress.Start("gallery")
+	defer progress.Stop()
+
+	var media index.Media
+	var bytes int64
+	for size, ms := range idx.GetMediaBySize() {
+		media = append(media, ms...)
+		bytes += size * int64(len(ms))
+	}
+	sort.Slice(media, func(i, j int) bool { return media[i].FullPath < media[j].FullPath })
+	progress.AddTotal(int64(len(media)), bytes)
+
+	// the sums written by cp are taken for the files unchanged since, not to hash them for the thumbnails
+	mf, err := index.LoadManifest(s, root)
+	if err != nil {
+		zap.L().Info("invalid manifest", zap.String("location", location), zap.Error(err))
+		mf = &index.Manifest{}
+	}
+	if err := mf.Sums(idx); err != nil {
+		return err
+	}
+	rels := make([]string, len(media))
+	for i, m := range media {
+		if rels[i], err = fs.Rel(s, root, m.FullPath); err != nil {
+			return err
+		}
+	}
+	assets := assetsName(rels)
+	if !c.Copy {
+		zap.L().Warn("the originals are linked, the site works only beside the archive", zap.String("archive", root))
+	}
+
+	// hashing, ffmpeg and copying are the work, by all the CPUs, the items keep the order of media
+	items := make([]*item, len(media))
+	errs := make([]error, len(media))
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				items[i], errs[i] = newItem(c, cache, site, assets, rels[i], media[i])
+				progress.Add(progress.Processed, 1, media[i].FileInfo.Size())
+			}
+		}()
+	}
+	for i := range media {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+
+	res := report.New("gallery", outcomeShown, outcomeNoThumb)
+	albums := map[string]*album{"": {}}
+	for i, m := range media {
+		it, err := items[i], errs[i]
+		if err != nil {
+			zap.L().Info("failed to add to gallery", zap.String("file", m.FullPath), zap.Error(err))
+			res.Fail(m.FullPath, err.Error())
+			continue
+		}
+		if it.thumb == "" {
+			res.Add(outcomeNoThumb, 1)
+		} else {
+			res.Add(outcomeShown, 1)
+		}
+
+		a := albumOf(albums, path.Dir(rels[i]))
+		a.items = append(a.items, it)
+	}
+
+	top := albums[""]
+	count(top)
+	if err := writePages(site, top, nil); err != nil {
+		return err
+	}
+	progress.Stop()
+
+	fmt.Printf("gallery of %d media at %s\n", top.count, filepath.Join(site, "index.html"))
+	res.Finish()
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if c.Report != "" {
+		if err := res.WriteJSON(c.Report); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
+
+// albumOf returns the album of dir, it and its parents are added if not yet
+func albumOf(albums map[string]*album, dir string) *album {
+	if dir == "." {
+		dir = ""
+	}
+	if a, ok := albums[dir]; ok {
+		return a
+	}
+
+	a := &album{rel: dir}
+	albums[dir] = a
+	parent := albumOf(albums, path.Dir(dir))
+	parent.albums = append(parent.albums, a)
+	return a
+}
+
+// count sorts the albums by name and the media by shooting time, and finds the covers
+func count(a *album) {
+	sort.Slice(a.albums, func(i, j int) bool { return a.albums[i].rel < a.albums[j].rel })
+	sort.SliceStable(a.items, func(i, j int) bool { return a.items[i].shooting.Before(a.items[j].shooting) })
+
+	a.count = len(a.items)
+	for _, it := range a.items {
+		if it.thumb != "" {
+			a.cover = it.thumb
+			break
+		}
+	}
+	for _, sub := range a.albums {
+		count(sub)
+		a.count += sub.count
+		if a.cover == "" {
+			a.cover = sub.cover
+		}
+	}
+}
+
+// assetsName returns the directory of the assets in the site, which is not an album at the top of the archive
+func assetsName(rels []string) string {
+	albums := make(map[string]bool)
+	for _, rel := range rels {
+		if i := strings.Index(rel, "/"); i > 0 {
+			// the file systems of the sites may ignore case
+			albums[strings.ToLower(rel[:i])] = true
+		}
+	}
+	name := assetsDir
+	for n := 1; albums[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s%d", assetsDir, n)
+	}
+	return name
+}
+
+func newItem(c *Config, cache *thumb.Cache, site string, assets string, rel string, m *index.Medium) (*item, error) {
+	it := &item{name: m.FileInfo.Name(), shooting: m.FileInfo.ModTime()}
+	if shooting := m.ShootingTime(); shooting.Valid() {
+		it.shooting = shooting.Time
+		it.time = shooting.Format("2006-01-02 15:04")
+	}
+	if meta := m.Meta(); meta != nil {
+		it.model = meta.Model
+		it.video = strings.HasPrefix(meta.MIMEType, "video/")
+	}
+	if place, ok := m.Place(); ok {
+		it.place = joinPlace(place.City, place.Region, place.Country)
+	} else if gps, ok := m.GPS(); ok {
+		it.place = fmt.Sprintf("%.4f, %.4f", gps.Latitude, gps.Longitude)
+	}
+
+	p, err := cache.Get(m)
+	switch {
+	case err == thumb.ErrUnsupported:
+	case err != nil:
+		// shown without thumbnail, ffmpeg may be missing
+		zap.L().Info("failed to thumbnail", zap.String("file", m.FullPath), zap.Error(err))
+	default:
+		it.thumb = path.Join(assets, "thumbs", filepath.Base(filepath.Dir(p)), filepath.Base(p))
+		if err := copyMissing(p, filepath.Join(site, filepath.FromSlash(it.thumb))); err != nil {
+			return nil, err
+		}
+	}
+
+	if !c.Copy {
+		original, err := filepath.Rel(site, m.FullPath)
+		if err != nil {
+			return nil, err
+		}
+		it.original = filepath.ToSlash(original)
+		return it, nil
+	}
+
+	it.original = path.Join(assets, "media", rel)
+	dst := filepath.Join(site, filepath.FromSlash(it.original))
+	if info, err := os.Stat(dst); err == nil && info.Size() == m.FileInfo.Size() && info.ModTime().Equal(m.FileInfo.ModTime()) {
+		return it, nil
+	}
+	if err := fs.CopyFile(m.Storage(), m.FullPath, fs.NewLocal(), dst); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+func joinPlace(names ...string) string {
+	var parts []string
+	for _, name := range names {
+		if name != "" && (len(parts) == 0 || parts[len(parts)-1] != name) {
+			parts = append(parts, name)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// copyMissing copies the thumbnail into the site, thumbnails of the same name have the same content
+func copyMissing(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// the items are made in parallel, a thumbnail shared by copies is never seen half written
+	w, err := ioutil.TempFile(filepath.Dir(dst), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if err := os.Chmod(w.Name(), os.FileMode(0644)); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return os.Rename(w.Name(), dst)
+}
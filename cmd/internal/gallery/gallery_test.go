@@ -0,0 +1,20 @@
+package gallery
+
+import "testing"
+
+func TestAssetsName(t *testing.T) {
+	tests := []struct {
+		rels []string
+		want string
+	}{
+		{[]string{"a.jpg", "2019/b.jpg"}, "_gallery"},
+		// a file is never an album
+		{[]string{"_gallery", "2019/b.jpg"}, "_gallery"},
+		{[]string{"_Gallery/a.jpg", "_gallery1/b.jpg"}, "_gallery2"},
+	}
+	for _, tt := range tests {
+		if got := assetsName(tt.rels); got != tt.want {
+			t.Errorf("assetsName(%q) = %s, want %s", tt.rels, got, tt.want)
+		}
+	}
+}
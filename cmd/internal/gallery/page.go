@@ -0,0 +1,168 @@
+package gallery
+
+import (
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type link struct {
+	Name string
+	Href string
+}
+
+type albumView struct {
+	Name  string
+	Href  string
+	Cover string
+	Count int
+}
+
+type itemView struct {
+	Name     string
+	Thumb    string
+	Original string
+	Video    bool
+	Time     string
+	Model    string
+	Place    string
+}
+
+type pageView struct {
+	Title  string
+	Crumbs []link
+	Count  int
+	Albums []albumView
+	Items  []itemView
+}
+
+// writePages writes the page of a and its albums, crumbs are the links to the parents
+func writePages(site string, a *album, crumbs []link) error {
+	dir := filepath.Join(site, filepath.FromSlash(a.rel))
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	// the links of the page are relative to its directory, so the site is browsed from any place
+	root := strings.Repeat("../", depth(a.rel))
+	v := &pageView{Title: a.name(), Crumbs: crumbs, Count: a.count}
+	for _, sub := range a.albums {
+		v.Albums = append(v.Albums, albumView{
+			Name:  sub.name(),
+			Href:  escape(sub.name()) + "/index.html",
+			Cover: href(root, sub.cover),
+			Count: sub.count,
+		})
+	}
+	for _, it := range a.items {
+		v.Items = append(v.Items, itemView{
+			Name:     it.name,
+			Thumb:    href(root, it.thumb),
+			Original: href(root, it.original),
+			Video:    it.video,
+			Time:     it.time,
+			Model:    it.model,
+			Place:    it.place,
+		})
+	}
+
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	if err := pageTemplate.Execute(f, v); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// the pages of the albums are a directory deeper
+	parents := make([]link, 0, len(crumbs)+1)
+	for _, l := range crumbs {
+		parents = append(parents, link{Name: l.Name, Href: "../" + l.Href})
+	}
+	parents = append(parents, link{Name: a.name(), Href: "../index.html"})
+	for _, sub := range a.albums {
+		if err := writePages(site, sub, parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func depth(rel string) int {
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// href links p relative to the root of the site from a page, every segment escaped
+func href(root string, p string) string {
+	if p == "" {
+		return ""
+	}
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		if s != ".." {
+			segments[i] = escape(s)
+		}
+	}
+	return root + strings.Join(segments, "/")
+}
+
+func escape(segment string) string {
+	return url.PathEscape(segment)
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; background: #fafafa; font-size: 18px; }
+nav { margin-bottom: 1em; }
+nav a { margin-right: .3em; }
+.grid { display: flex; flex-wrap: wrap; gap: 1em; }
+.card { width: 240px; background: #fff; border: 1px solid #ddd; padding: .5em; }
+.card a { text-decoration: none; color: inherit; }
+.thumb { width: 240px; height: 240px; display: flex; align-items: center; justify-content: center; background: #eee; position: relative; overflow: hidden; }
+.thumb img { max-width: 240px; max-height: 240px; }
+.play { position: absolute; font-size: 48px; color: #fff; text-shadow: 0 0 6px #000; }
+.caption { font-size: 14px; color: #555; margin-top: .3em; word-break: break-all; }
+.caption b { color: #000; }
+h2 { margin-top: 1.5em; }
+</style>
+</head>
+<body>
+<nav>{{range .Crumbs}}<a href="{{.Href}}">{{.Name}}</a> / {{end}}<b>{{.Title}}</b> ({{.Count}})</nav>
+{{if .Albums}}
+<div class="grid">
+{{range .Albums}}
+<div class="card"><a href="{{.Href}}">
+<div class="thumb">{{if .Cover}}<img src="{{.Cover}}" alt="">{{end}}</div>
+<div class="caption"><b>{{.Name}}</b><br>{{.Count}}</div>
+</a></div>
+{{end}}
+</div>
+{{end}}
+{{if .Items}}
+{{if .Albums}}<h2>{{.Title}}</h2>{{end}}
+<div class="grid">
+{{range .Items}}
+<div class="card"><a href="{{.Original}}" target="_blank">
+<div class="thumb">{{if .Thumb}}<img src="{{.Thumb}}" loading="lazy" alt="{{.Name}}">{{else}}{{.Name}}{{end}}{{if .Video}}<span class="play">&#9654;</span>{{end}}</div>
+<div class="caption">{{if .Time}}<b>{{.Time}}</b><br>{{end}}{{if .Model}}{{.Model}}<br>{{end}}{{if .Place}}{{.Place}}<br>{{end}}{{.Name}}</div>
+</a></div>
+{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
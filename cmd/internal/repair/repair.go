@@ -0,0 +1,144 @@
+package repair
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/parity"
+)
+
+const (
+	outcomeUpdated  report.Outcome = "updated"
+	outcomeUpToDate report.Outcome = "up-to-date"
+
+	outcomeIntact   report.Outcome = "intact"
+	outcomeRepaired report.Outcome = "repaired"
+	outcomeDamaged  report.Outcome = "damaged"
+	outcomeModified report.Outcome = "skipped-modified"
+)
+
+type Config struct {
+	DryRun bool `mapstructure:"dry-run"`
+	Parity parity.Config
+	Report string
+}
+
+// Protect generates the parity of every directory under the locations whose files changed since their parity.
+func Protect(c *Config, locations []string) error {
+	res := report.New("parity", outcomeUpdated, outcomeUpToDate)
+	for _, location := range locations {
+		err := walkDirs(location, func(s fs.Storage, dir string) {
+			ok, err := parity.UpToDate(s, dir, &c.Parity)
+			if err != nil {
+				res.Fail(dir, err.Error())
+				return
+			}
+			if ok {
+				res.Add(outcomeUpToDate, 1)
+				return
+			}
+			if !c.DryRun {
+				if err := parity.Write(s, dir, &c.Parity); err != nil {
+					zap.L().Info("failed to write parity", zap.String("directory", dir), zap.Error(err))
+					res.Fail(dir, err.Error())
+					return
+				}
+			}
+			zap.L().Info("parity updated", zap.String("directory", dir))
+			res.Add(outcomeUpdated, 1)
+		})
+		if err != nil {
+			res.Fail(location, err.Error())
+		}
+	}
+	return finish(c, res)
+}
+
+// Run checks the files of every directory under the locations by their parity, and repairs the damaged ones.
+func Run(c *Config, locations []string) error {
+	res := report.New("repair", outcomeIntact, outcomeRepaired, outcomeDamaged, outcomeModified)
+	for _, location := range locations {
+		err := walkDirs(location, func(s fs.Storage, dir string) {
+			r, err := parity.Repair(s, dir, c.DryRun)
+			if err == parity.ErrNoParity {
+				return
+			}
+			if err != nil {
+				zap.L().Info("failed to repair", zap.String("directory", dir), zap.Error(err))
+				res.Fail(dir, err.Error())
+				return
+			}
+
+			res.Add(outcomeIntact, r.Intact)
+			res.Add(outcomeModified, len(r.Modified))
+			if c.DryRun {
+				res.Add(outcomeDamaged, len(r.Damaged))
+				for _, name := range r.Damaged {
+					fmt.Printf("damaged\t%s\n", fs.Join(s, dir, name))
+				}
+				return
+			}
+			res.Add(outcomeRepaired, len(r.Repaired))
+			for _, name := range r.Repaired {
+				zap.L().Info("repaired", zap.String("file", fs.Join(s, dir, name)))
+			}
+			for name, reason := range r.Failed {
+				res.Fail(fs.Join(s, dir, name), reason)
+			}
+			if r.BadParity > 0 {
+				zap.L().Info("damaged parity, run parity to rewrite it",
+					zap.String("directory", dir), zap.Int("blocks", r.BadParity))
+			}
+		})
+		if err != nil {
+			res.Fail(location, err.Error())
+		}
+	}
+	return finish(c, res)
+}
+
+// walkDirs calls fn with every directory under location, except the ones of bkpic itself
+func walkDirs(location string, fn func(s fs.Storage, dir string)) error {
+	s, root, err := fs.Parse(location)
+	if err != nil {
+		return err
+	}
+	if fs.IsLocal(s) {
+		if root, err = filepath.Abs(root); err != nil {
+			return err
+		}
+	}
+
+	return fs.Walk(s, root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == index.MetaDir {
+			return filepath.SkipDir
+		}
+		fn(s, p)
+		return nil
+	})
+}
+
+func finish(c *Config, res *report.Result) error {
+	res.Finish()
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if c.Report != "" {
+		if err := res.WriteJSON(c.Report); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
@@ -0,0 +1,144 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Failed is the outcome of the files which could not be handled, it makes the run a partial failure.
+const Failed Outcome = "failed"
+
+var ErrPartialFailure = errors.New("some files failed")
+
+type Outcome string
+
+type Failure struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Result is the summary of a run, the count of files by outcome and the reasons of the failures.
+type Result struct {
+	Command  string          `json:"command"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Counts   map[Outcome]int `json:"counts"`
+	Failures []Failure       `json:"failures,omitempty"`
+	// Tallies count the files by other facets, like the source of shooting time
+	Tallies map[string]map[string]int `json:"tallies,omitempty"`
+
+	outcomes []Outcome
+}
+
+// New creates the result of command, outcomes are listed in the given order and Failed is always the last.
+func New(command string, outcomes ...Outcome) *Result {
+	r := &Result{
+		Command:  command,
+		Started:  time.Now(),
+		Counts:   make(map[Outcome]int),
+		outcomes: append(outcomes, Failed),
+	}
+	for _, o := range r.outcomes {
+		r.Counts[o] = 0
+	}
+	return r
+}
+
+func (r *Result) Add(o Outcome, n int) {
+	r.Counts[o] += n
+}
+
+// Tally counts a file by key of facet.
+func (r *Result) Tally(facet string, key string) {
+	if r.Tallies == nil {
+		r.Tallies = make(map[string]map[string]int)
+	}
+	if r.Tallies[facet] == nil {
+		r.Tallies[facet] = make(map[string]int)
+	}
+	r.Tallies[facet][key]++
+}
+
+func (r *Result) Fail(path string, reason string) {
+	r.Counts[Failed]++
+	r.Failures = append(r.Failures, Failure{Path: path, Reason: reason})
+}
+
+func (r *Result) Total() int {
+	var total int
+	for _, n := range r.Counts {
+		total += n
+	}
+	return total
+}
+
+// Finish marks the end of the run.
+func (r *Result) Finish() {
+	r.Finished = time.Now()
+}
+
+// Err returns ErrPartialFailure if any file failed.
+func (r *Result) Err() error {
+	if r.Counts[Failed] > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrPartialFailure, r.Counts[Failed], r.Total())
+	}
+	return nil
+}
+
+func (r *Result) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "OUTCOME\tFILES\n")
+	for _, o := range r.outcomes {
+		fmt.Fprintf(tw, "%s\t%d\n", o, r.Counts[o])
+	}
+	fmt.Fprintf(tw, "total\t%d\n", r.Total())
+
+	facets := make([]string, 0, len(r.Tallies))
+	for facet := range r.Tallies {
+		facets = append(facets, facet)
+	}
+	sort.Strings(facets)
+	for _, facet := range facets {
+		tally := r.Tallies[facet]
+		keys := make([]string, 0, len(tally))
+		for k := range tally {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(tw, "\n%s\tFILES\n", strings.ToUpper(facet))
+		for _, k := range keys {
+			fmt.Fprintf(tw, "%s\t%d\n", k, tally[k])
+		}
+	}
+
+	if len(r.Failures) > 0 {
+		fmt.Fprintf(tw, "\nFAILED\tREASON\n")
+		for _, f := range r.Failures {
+			fmt.Fprintf(tw, "%s\t%s\n", f.Path, f.Reason)
+		}
+	}
+	return tw.Flush()
+}
+
+func (r *Result) WriteJSON(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
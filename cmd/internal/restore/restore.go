@@ -0,0 +1,320 @@
+package restore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+	"github.com/enjoypi/bkpic/store"
+)
+
+const (
+	outcomeRestored report.Outcome = "restored"
+	outcomeExisting report.Outcome = "skipped-existing"
+
+	dateLayout = "2006-01-02"
+)
+
+type Config struct {
+	DryRun   bool `mapstructure:"dry-run"`
+	Output   string
+	Report   string
+	Snapshot string
+	// Glob matches the base names, or the paths relative to the inputs if it has /
+	Glob string
+	// Since and Until are dates of shooting time in its zone, or modification time for the media without it
+	Since string
+	Until string
+	Model string
+}
+
+func (c *Config) check() error {
+	if _, err := path.Match(c.Glob, ""); err != nil {
+		return fmt.Errorf("invalid path glob %q: %w", c.Glob, err)
+	}
+
+	for _, date := range []string{c.Since, c.Until} {
+		if _, err := time.Parse(dateLayout, date); date != "" && err != nil {
+			return fmt.Errorf("invalid date %q, expect %s", date, dateLayout)
+		}
+	}
+	return nil
+}
+
+// needsMeta reports whether the filters need the shooting times and the models
+func (c *Config) needsMeta() bool {
+	return c.Since != "" || c.Until != "" || c.Model != ""
+}
+
+// match reports whether e of rel is selected by the filters
+func (c *Config) match(e *store.Entry, rel string) bool {
+	if c.Glob != "" {
+		name := rel
+		if !strings.Contains(c.Glob, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(c.Glob, name); !ok {
+			return false
+		}
+	}
+
+	// the date of shooting is of its own zone like the directories of cp, the one without offset is in DefaultLocation
+	date := e.ModTime.In(index.DefaultLocation)
+	if e.Shooting != nil {
+		date = *e.Shooting
+	}
+	day := date.Format(dateLayout)
+	if c.Since != "" && day < c.Since {
+		return false
+	}
+	if c.Until != "" && day > c.Until {
+		return false
+	}
+
+	if c.Model != "" && !strings.EqualFold(c.Model, e.Model) {
+		return false
+	}
+	return true
+}
+
+// source is where the files to restore are read from, a snapshot of a backup store or the manifest of a cp output
+type source struct {
+	name  string
+	files []store.Entry
+	// rel gives the path of a file under output
+	rel  func(e *store.Entry) (string, error)
+	open func(e *store.Entry, sum []byte) (io.ReadCloser, error)
+	// meta reads the shooting time and the model of a file if they are not kept
+	meta func(e *store.Entry)
+}
+
+// openSource opens the snapshot of the backup store at location,
+// or the files in the manifest of the cp output at location if it has no snapshots
+func openSource(c *Config, location string) (*source, error) {
+	st, err := store.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := st.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		snap, err := st.LoadSnapshot(c.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return &source{
+			name:  "snapshot " + snap.ID,
+			files: snap.Files,
+			rel:   func(e *store.Entry) (string, error) { return relPath(snap, e.Path), nil },
+			open:  func(e *store.Entry, sum []byte) (io.ReadCloser, error) { return st.OpenObject(sum) },
+		}, nil
+	}
+
+	if c.Snapshot != store.Latest {
+		return nil, fmt.Errorf("no snapshot %s in %s", c.Snapshot, location)
+	}
+	s, root := st.Storage(), st.Root()
+	mf, err := index.LoadManifest(s, root)
+	if err != nil {
+		return nil, err
+	}
+	if len(mf.Files) == 0 {
+		return nil, fmt.Errorf("neither a backup store nor a cp output with manifest: %s", location)
+	}
+
+	rels := make([]string, 0, len(mf.Files))
+	for rel := range mf.Files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	files := make([]store.Entry, 0, len(rels))
+	for _, rel := range rels {
+		e := mf.Files[rel]
+		files = append(files, store.Entry{Path: fs.Join(s, root, rel), SHA256: e.SHA256, Size: e.Size, ModTime: e.ModTime})
+	}
+	return &source{
+		name:  location,
+		files: files,
+		rel:   func(e *store.Entry) (string, error) { return fs.Rel(s, root, e.Path) },
+		// the files are checked by the sums of the manifest as the objects are, a damaged one is not restored
+		open: func(e *store.Entry, sum []byte) (io.ReadCloser, error) { return s.Open(e.Path) },
+		meta: func(e *store.Entry) {
+			m := index.NewStorageMedium(s, e.Path)
+			if m == nil {
+				return
+			}
+			if shooting := m.ShootingTime(); shooting.Valid() {
+				t := shooting.Time
+				e.Shooting = &t
+			}
+			if meta := m.Meta(); meta != nil {
+				e.Model = strings.TrimSpace(meta.Model)
+			}
+		},
+	}, nil
+}
+
+// Run restores the snapshot in the backup store of location, or the files of the cp output at location,
+// into output with the original names and modification times.
+func Run(c *Config, location string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+
+	src, err := openSource(c, location)
+	if err != nil {
+		return err
+	}
+
+	storage, output, err := fs.Parse(c.Output)
+	if err != nil {
+		return err
+	}
+	if fs.IsLocal(storage) {
+		if output, err = filepath.Abs(output); err != nil {
+			return err
+		}
+	}
+
+	progress.Start("restore")
+	defer progress.Stop()
+
+	var selected []*store.Entry
+	var rels []string
+	var size int64
+	for i := range src.files {
+		e := &src.files[i]
+		rel, err := src.rel(e)
+		if err != nil {
+			return err
+		}
+		if src.meta != nil && c.needsMeta() {
+			src.meta(e)
+		}
+		if c.match(e, rel) {
+			selected = append(selected, e)
+			rels = append(rels, rel)
+			size += e.Size
+		}
+	}
+	progress.AddTotal(int64(len(selected)), size)
+
+	res := report.New("restore", outcomeRestored, outcomeExisting)
+	for i, e := range selected {
+		target := fs.Join(storage, output, rels[i])
+		outcome, err := restoreFile(c, src, e, storage, target)
+		if err != nil {
+			zap.L().Info("failed to restore", zap.String("file", e.Path), zap.String("target", target), zap.Error(err))
+			res.Fail(e.Path, err.Error())
+		} else {
+			res.Add(outcome, 1)
+			if c.DryRun {
+				fmt.Printf("%s\t%s\n", e.Path, target)
+			}
+		}
+		progress.Add(progress.Processed, 1, e.Size)
+	}
+	progress.Stop()
+
+	res.Finish()
+	fmt.Printf("%s: %d of %d files\n", src.name, len(selected), len(src.files))
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if c.Report != "" {
+		if err := res.WriteJSON(c.Report); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
+
+// relPath returns the path of p under the base name of its input, like DCIM/a.jpg of /phone is phone/DCIM/a.jpg
+func relPath(snap *store.Snapshot, p string) string {
+	p = filepath.ToSlash(p)
+	in := snap.Input(p)
+	if in == "" {
+		return strings.TrimPrefix(path.Clean("/"+p), "/")
+	}
+	in = filepath.ToSlash(in)
+	return path.Join(path.Base(in), strings.TrimPrefix(p, strings.TrimSuffix(in, "/")))
+}
+
+// restoreFile writes the file of e to target, it is removed if its checksum mismatches
+func restoreFile(c *Config, src *source, e *store.Entry, s fs.Storage, target string) (report.Outcome, error) {
+	sum, err := hex.DecodeString(e.SHA256)
+	if err != nil || len(sum) != sha256.Size {
+		return "", fmt.Errorf("invalid checksum %q", e.SHA256)
+	}
+
+	if info, err := s.Stat(target); err == nil {
+		if info.Size() == e.Size && sameContent(s, target, sum) {
+			return outcomeExisting, nil
+		}
+		return "", fmt.Errorf("target exists with different content")
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if c.DryRun {
+		return outcomeRestored, nil
+	}
+
+	r, err := src.open(e, sum)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	dst, err := s.Create(target, e.ModTime)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, h), r); err != nil {
+		dst.Close()
+		_ = s.Remove(target)
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		_ = s.Remove(target)
+		return "", err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		_ = s.Remove(target)
+		return "", fmt.Errorf("checksum mismatch of %s, want %s", e.Path, e.SHA256)
+	}
+
+	progress.Add(progress.Copied, 1, e.Size)
+	return outcomeRestored, nil
+}
+
+func sameContent(s fs.Storage, file string, sum []byte) bool {
+	r, err := s.Open(file)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return false
+	}
+	return bytes.Equal(h.Sum(nil), sum)
+}
@@ -0,0 +1,209 @@
+package serve
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/tidy"
+	"github.com/enjoypi/bkpic/index"
+)
+
+// metaRow is a field of the files of a group, Differ marks the fields which tell them apart
+type metaRow struct {
+	Name   string
+	Values []string
+	Differ bool
+}
+
+type fileView struct {
+	Path   string
+	Thumb  bool
+	Action string
+}
+
+type groupView struct {
+	Index    int
+	Size     int64
+	Approved bool
+	Files    []*fileView
+	Rows     []*metaRow
+}
+
+type pageView struct {
+	Page, Pages int
+	Groups      []*groupView
+	Total       int
+	Approved    int
+	Prev, Next  int
+	Token       string
+}
+
+func (s *server) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := s.plan.Groups
+	pages := (len(groups) + groupsPerPage - 1) / groupsPerPage
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+
+	v := &pageView{Page: page, Pages: pages, Total: len(groups), Token: s.token}
+	for _, g := range groups {
+		if g.Approved {
+			v.Approved++
+		}
+	}
+	if page > 1 {
+		v.Prev = page - 1
+	}
+	if page < pages {
+		v.Next = page + 1
+	}
+	for i := (page - 1) * groupsPerPage; i >= 0 && i < len(groups) && i < page*groupsPerPage; i++ {
+		v.Groups = append(v.Groups, s.groupView(i, groups[i]))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pageTemplate.Execute(w, v); err != nil {
+		zap.L().Info("failed to render page", zap.Error(err))
+	}
+}
+
+func (s *server) groupView(i int, g *tidy.Group) *groupView {
+	v := &groupView{Index: i, Size: g.Size, Approved: g.Approved}
+
+	var media []*index.Medium
+	for _, f := range g.Files {
+		m := s.media.Get(f.Path)
+		fv := &fileView{Path: f.Path, Action: f.Action}
+		if m != nil {
+			if meta := m.Meta(); meta != nil {
+				fv.Thumb = strings.HasPrefix(meta.MIMEType, "image/") || strings.HasPrefix(meta.MIMEType, "video/")
+			}
+		}
+		v.Files = append(v.Files, fv)
+		media = append(media, m)
+	}
+
+	fields := []struct {
+		name  string
+		value func(m *index.Medium) string
+	}{
+		{"modified", func(m *index.Medium) string { return m.FileInfo.ModTime().Format("2006-01-02 15:04:05") }},
+		{"shooting time", func(m *index.Medium) string {
+			if t := m.ShootingTime(); t.Valid() {
+				return fmt.Sprintf("%s (%s)", t.Format("2006-01-02 15:04:05 -07:00"), t.Source)
+			}
+			return ""
+		}},
+		{"type", func(m *index.Medium) string { return meta(m).MIMEType }},
+		{"dimensions", func(m *index.Medium) string {
+			if meta(m).ImageWidth > 0 {
+				return fmt.Sprintf("%d×%d", meta(m).ImageWidth, meta(m).ImageHeight)
+			}
+			return ""
+		}},
+		{"model", func(m *index.Medium) string { return meta(m).Model }},
+		{"serial", func(m *index.Medium) string { return meta(m).Serial() }},
+		{"gps", func(m *index.Medium) string {
+			if meta(m).GPSLatitude != "" {
+				return meta(m).GPSLatitude + ", " + meta(m).GPSLongitude
+			}
+			return ""
+		}},
+	}
+	for _, field := range fields {
+		row := &metaRow{Name: field.name}
+		for _, m := range media {
+			var value string
+			if m != nil {
+				value = field.value(m)
+			}
+			if len(row.Values) > 0 && value != row.Values[0] {
+				row.Differ = true
+			}
+			row.Values = append(row.Values, value)
+		}
+		v.Rows = append(v.Rows, row)
+	}
+	return v
+}
+
+// meta is never nil, the fields of a medium without meta are empty
+func meta(m *index.Medium) *index.Meta {
+	if meta := m.Meta(); meta != nil {
+		return meta
+	}
+	return &index.Meta{}
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>bkpic duplicates</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+.group { border: 1px solid #ccc; margin: 1em 0; padding: .5em; }
+.group.approved { border-color: #4a4; }
+.files { display: flex; gap: 1em; overflow-x: auto; }
+.file { width: 320px; flex: none; }
+.file img { max-width: 320px; max-height: 320px; display: block; }
+.file .none { width: 320px; height: 120px; background: #eee; display: flex; align-items: center; justify-content: center; }
+.file.rm { opacity: .6; }
+.path { word-break: break-all; font-size: small; }
+table { border-collapse: collapse; font-size: small; margin-top: .5em; }
+td, th { border: 1px solid #ddd; padding: 2px 6px; text-align: left; vertical-align: top; }
+tr.differ td { background: #ffd; }
+nav { margin: 1em 0; }
+</style>
+</head>
+<body>
+<h1>{{.Total}} groups, {{.Approved}} approved</h1>
+<form method="post" action="/save">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="page" value="{{.Page}}">
+<nav>page {{.Page}} of {{.Pages}}
+{{if .Prev}}<a href="/?page={{.Prev}}">previous</a>{{end}}
+{{if .Next}}<a href="/?page={{.Next}}">next</a>{{end}}
+<button type="submit">save</button></nav>
+{{range $g := .Groups}}
+<div class="group{{if $g.Approved}} approved{{end}}">
+<input type="hidden" name="group" value="{{$g.Index}}">
+<label><input type="checkbox" name="approved-{{$g.Index}}"{{if $g.Approved}} checked{{end}}> approve group {{$g.Index}}</label>, {{$g.Size}} bytes
+<div class="files">
+{{range $j, $f := $g.Files}}
+<div class="file {{$f.Action}}">
+<a href="/file?path={{$f.Path}}" target="_blank">{{if $f.Thumb}}<img src="/thumb?path={{$f.Path}}" loading="lazy" alt="">{{else}}<div class="none">no thumbnail</div>{{end}}</a>
+<div class="path">{{$f.Path}}</div>
+<label><input type="radio" name="action-{{$g.Index}}-{{$j}}" value="keep"{{if eq $f.Action "keep"}} checked{{end}}> keep</label>
+<label><input type="radio" name="action-{{$g.Index}}-{{$j}}" value="rm"{{if eq $f.Action "rm"}} checked{{end}}> rm</label>
+</div>
+{{end}}
+</div>
+<table>
+{{range $g.Rows}}<tr{{if .Differ}} class="differ"{{end}}><th>{{.Name}}</th>{{range .Values}}<td>{{.}}</td>{{end}}</tr>
+{{end}}
+</table>
+</div>
+{{end}}
+<nav><button type="submit">save</button></nav>
+</form>
+</body>
+</html>
+`))
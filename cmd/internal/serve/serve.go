@@ -0,0 +1,168 @@
+package serve
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/tidy"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+	"github.com/enjoypi/bkpic/thumb"
+)
+
+// groupsPerPage keeps a page of thumbnails light
+const groupsPerPage = 50
+
+type Config struct {
+	Listen string
+	Plan   string
+	// Cache is the directory of thumbnails, the one of the user if empty
+	Cache string
+}
+
+// server reviews the groups of duplicates, every decision is saved to the plan at once
+type server struct {
+	c      *Config
+	mu     sync.Mutex
+	plan   *tidy.Plan
+	media  *index.Index
+	thumbs *thumb.Cache
+	// hosts are the Host headers of the server, token is of the forms of this run
+	hosts map[string]bool
+	token string
+}
+
+// Run finds the duplicates of the directories and serves them for review until interrupted.
+func Run(c *Config, dirs []string) error {
+	progress.Start("serve")
+	groups, err := tidy.FindGroups(dirs)
+	progress.Stop()
+	if err != nil {
+		return err
+	}
+
+	plan := &tidy.Plan{Groups: groups}
+	old, err := tidy.LoadPlan(c.Plan)
+	switch {
+	case err == nil:
+		plan.Merge(old)
+	case !os.IsNotExist(err):
+		return err
+	}
+	if err := plan.Save(c.Plan); err != nil {
+		return err
+	}
+
+	// only the files of the groups are served, with their meta read at once
+	var files []string
+	for _, g := range plan.Groups {
+		for _, f := range g.Files {
+			files = append(files, f.Path)
+		}
+	}
+	media := index.NewFilesIndex(fs.NewLocal(), "", files)
+	if err := media.LoadMeta(); err != nil {
+		zap.L().Info("failed to read meta", zap.Error(err))
+	}
+
+	thumbs, err := thumb.Open(c.Cache)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := hosts(c.Listen)
+	if err != nil {
+		return err
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	s := &server{c: c, plan: plan, media: media, thumbs: thumbs, hosts: allowed, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.page)
+	mux.HandleFunc("/save", s.save)
+	mux.HandleFunc("/thumb", s.thumb)
+	mux.HandleFunc("/file", s.file)
+
+	fmt.Printf("reviewing %d groups at http://%s, the decisions are saved to %s\n", len(plan.Groups), c.Listen, c.Plan)
+	return http.ListenAndServe(c.Listen, s.guard(mux))
+}
+
+// medium returns the medium of a group by the path of the request
+func (s *server) medium(w http.ResponseWriter, r *http.Request) *index.Medium {
+	m := s.media.Get(r.URL.Query().Get("path"))
+	if m == nil {
+		http.NotFound(w, r)
+	}
+	return m
+}
+
+func (s *server) file(w http.ResponseWriter, r *http.Request) {
+	m := s.medium(w, r)
+	if m == nil {
+		return
+	}
+
+	f, err := os.Open(m.FullPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, r, m.FileInfo.Name(), m.FileInfo.ModTime(), f)
+}
+
+// save takes the decisions of the groups of a page
+func (s *server) save(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.sameOrigin(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, _ := strconv.Atoi(r.PostForm.Get("page"))
+	for _, v := range r.PostForm["group"] {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= len(s.plan.Groups) {
+			http.Error(w, "invalid group "+v, http.StatusBadRequest)
+			return
+		}
+
+		g := s.plan.Groups[i]
+		for j, f := range g.Files {
+			switch action := r.PostForm.Get(fmt.Sprintf("action-%d-%d", i, j)); action {
+			case tidy.ActionKeep, tidy.ActionRemove:
+				f.Action = action
+			}
+		}
+		g.Approved = r.PostForm.Get(fmt.Sprintf("approved-%d", i)) != ""
+		if g.Approved && g.Kept() == 0 {
+			g.Approved = false
+			zap.L().Info("group keeps no file, not approved", zap.Int("group", i))
+		}
+	}
+
+	if err := s.plan.Save(s.c.Plan); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/?page=%d", page), http.StatusSeeOther)
+}
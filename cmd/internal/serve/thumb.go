@@ -0,0 +1,38 @@
+package serve
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/thumb"
+)
+
+// thumb serves the cached thumbnail of an image or a video, it is generated on the first request
+func (s *server) thumb(w http.ResponseWriter, r *http.Request) {
+	m := s.medium(w, r)
+	if m == nil {
+		return
+	}
+
+	// the medium is hashed and its meta read once under the lock, then it is only read,
+	// so the thumbnails are generated in parallel without holding the page and the decisions
+	s.mu.Lock()
+	m.SumSHA256()
+	m.Meta()
+	s.mu.Unlock()
+
+	p, err := s.thumbs.Get(m)
+	if err == thumb.ErrUnsupported {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		zap.L().Info("failed to thumbnail", zap.String("file", m.FullPath), zap.Error(err))
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "max-age=3600")
+	http.ServeFile(w, r, p)
+}
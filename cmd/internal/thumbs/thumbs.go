@@ -0,0 +1,118 @@
+package thumbs
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+	"github.com/enjoypi/bkpic/thumb"
+)
+
+const (
+	outcomeCreated     report.Outcome = "created"
+	outcomeCached      report.Outcome = "cached"
+	outcomeUnsupported report.Outcome = "skipped-unsupported"
+)
+
+type Config struct {
+	// Cache is the directory of thumbnails, the one of the user if empty
+	Cache  string
+	Report string
+}
+
+// Run builds the thumbnails of the media in the locations, the cached ones are kept.
+func Run(c *Config, locations []string) error {
+	cache, err := thumb.Open(c.Cache)
+	if err != nil {
+		return err
+	}
+
+	progress.Start("thumbs")
+	defer progress.Stop()
+
+	res := report.New("thumbs", outcomeCreated, outcomeCached, outcomeUnsupported)
+	var mu sync.Mutex
+	for _, location := range locations {
+		idx, err := index.NewIndex(location)
+		if err != nil {
+			zap.L().Info("invalid input directory", zap.String("input", location), zap.Error(err))
+			res.Fail(location, err.Error())
+			continue
+		}
+		if err := idx.LoadMeta(); err != nil {
+			zap.L().Info("invalid input directory", zap.String("input", location), zap.Error(err))
+			res.Fail(location, err.Error())
+			continue
+		}
+
+		var media index.Media
+		var bytes int64
+		for size, ms := range idx.GetMediaBySize() {
+			media = append(media, ms...)
+			bytes += size * int64(len(ms))
+		}
+		sort.Slice(media, func(i, j int) bool { return media[i].FullPath < media[j].FullPath })
+		progress.AddTotal(int64(len(media)), bytes)
+
+		// ffmpeg and decoding are the work, by all the CPUs
+		ch := make(chan *index.Medium)
+		var wg sync.WaitGroup
+		for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for m := range ch {
+					o, err := build(cache, m)
+					progress.Add(progress.Processed, 1, m.FileInfo.Size())
+
+					mu.Lock()
+					if err != nil {
+						zap.L().Info("failed to thumbnail", zap.String("file", m.FullPath), zap.Error(err))
+						res.Fail(m.FullPath, err.Error())
+					} else {
+						res.Add(o, 1)
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		for _, m := range media {
+			ch <- m
+		}
+		close(ch)
+		wg.Wait()
+	}
+	progress.Stop()
+
+	res.Finish()
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if c.Report != "" {
+		if err := res.WriteJSON(c.Report); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
+
+func build(cache *thumb.Cache, m *index.Medium) (report.Outcome, error) {
+	if cache.Has(m) {
+		return outcomeCached, nil
+	}
+	p, err := cache.Get(m)
+	if err == thumb.ErrUnsupported {
+		return outcomeUnsupported, nil
+	}
+	if err != nil {
+		return report.Failed, err
+	}
+	zap.L().Debug("thumbnail", zap.String("file", m.FullPath), zap.String("thumbnail", p))
+	return outcomeCreated, nil
+}
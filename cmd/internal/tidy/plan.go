@@ -0,0 +1,291 @@
+package tidy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+)
+
+const (
+	ActionKeep   = "keep"
+	ActionRemove = "rm"
+
+	outcomeRemoved    report.Outcome = "removed"
+	outcomeKept       report.Outcome = "kept"
+	outcomeUnapproved report.Outcome = "skipped-unapproved"
+)
+
+// PlanFile is a file of a group and what to do with it.
+type PlanFile struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	// SHA256 is of the content when planned, a file changed since is never removed
+	SHA256 string `json:"sha256"`
+}
+
+// Group is the same media of a size, the removals of an approved group are executed by tidy.
+type Group struct {
+	Size     int64       `json:"size"`
+	Files    []*PlanFile `json:"files"`
+	Approved bool        `json:"approved"`
+}
+
+// Plan is the decisions on the groups of duplicates, reviewed by serve.
+type Plan struct {
+	Groups []*Group `json:"groups"`
+}
+
+// newGroup proposes to remove the one file of same which tidy prints, the others are kept.
+func newGroup(size int64, same []string, cfg *config) *Group {
+	rm := propose(same, cfg)
+
+	g := &Group{Size: size}
+	for i, path := range same {
+		action := ActionKeep
+		if i == rm {
+			action = ActionRemove
+		}
+		g.Files = append(g.Files, &PlanFile{Path: path, Action: action})
+	}
+	return g
+}
+
+// key identifies the group by its files
+func (g *Group) key() string {
+	paths := make([]string, 0, len(g.Files))
+	for _, f := range g.Files {
+		paths = append(paths, f.Path)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, "\n")
+}
+
+// Kept returns the number of files to keep, an approved group keeps one at least.
+func (g *Group) Kept() int {
+	var n int
+	for _, f := range g.Files {
+		if f.Action == ActionKeep {
+			n++
+		}
+	}
+	return n
+}
+
+// FindGroups finds the same media of every size in the directories, the media of different sizes are never grouped,
+// the keeper of each group is proposed.
+func FindGroups(dirs []string) ([]*Group, error) {
+	cfg := loadConfig()
+
+	idx := index.NewEmptyIndex()
+	for _, dir := range dirs {
+		if err := idx.Walk(dir, cfg.Ignored); err != nil {
+			return nil, err
+		}
+	}
+
+	var mu sync.Mutex
+	var groups []*Group
+	findSame(idx, func(size int64, same []string) {
+		g := newGroup(size, same, &cfg)
+		for _, f := range g.Files {
+			if m := idx.Get(f.Path); m != nil {
+				m.SumSHA256()
+				f.SHA256 = hex.EncodeToString(m.SHA256)
+			}
+		}
+		mu.Lock()
+		groups = append(groups, g)
+		mu.Unlock()
+	})
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Size != groups[j].Size {
+			return groups[i].Size > groups[j].Size
+		}
+		return groups[i].Files[0].Path < groups[j].Files[0].Path
+	})
+	return groups, nil
+}
+
+func LoadPlan(path string) (*Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var p Plan
+	if err := json.NewDecoder(f).Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Save writes the plan by a temporary file, the plan is never left half written.
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, os.FileMode(0600)); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Merge takes the decisions of old for the groups of the same files,
+// a group whose files changed since is to be approved again.
+func (p *Plan) Merge(old *Plan) {
+	decided := make(map[string]*Group, len(old.Groups))
+	for _, g := range old.Groups {
+		decided[g.key()] = g
+	}
+	for _, g := range p.Groups {
+		d, ok := decided[g.key()]
+		if !ok {
+			continue
+		}
+
+		old := make(map[string]*PlanFile, len(d.Files))
+		for _, f := range d.Files {
+			old[f.Path] = f
+		}
+		g.Approved = d.Approved
+		for _, f := range g.Files {
+			o := old[f.Path]
+			f.Action = o.Action
+			if o.SHA256 != f.SHA256 {
+				g.Approved = false
+			}
+		}
+	}
+}
+
+// runPlan removes the files of the approved groups of the plan saved by serve
+func runPlan(v *viper.Viper, path string) error {
+	plan, err := LoadPlan(path)
+	if err != nil {
+		return err
+	}
+	dryRun := v.GetBool("dry-run")
+
+	progress.Start("tidy")
+	defer progress.Stop()
+	for _, g := range plan.Groups {
+		progress.AddTotal(int64(len(g.Files)), 0)
+	}
+
+	res := report.New("tidy", outcomeRemoved, outcomeKept, outcomeUnapproved)
+	for _, g := range plan.Groups {
+		progress.Add(progress.Processed, int64(len(g.Files)), 0)
+		if !g.Approved {
+			res.Add(outcomeUnapproved, len(g.Files))
+			continue
+		}
+		if reason := check(g); reason != "" {
+			for _, f := range g.Files {
+				res.Fail(f.Path, reason)
+			}
+			continue
+		}
+
+		for _, f := range g.Files {
+			if f.Action == ActionKeep {
+				res.Add(outcomeKept, 1)
+				continue
+			}
+			if dryRun {
+				fmt.Printf("rm \"%s\"\n", f.Path)
+				res.Add(outcomeRemoved, 1)
+				continue
+			}
+			if err := os.Remove(f.Path); err != nil {
+				zap.L().Info("failed to remove", zap.String("file", f.Path), zap.Error(err))
+				res.Fail(f.Path, err.Error())
+				continue
+			}
+			zap.L().Info("removed", zap.String("file", f.Path))
+			res.Add(outcomeRemoved, 1)
+		}
+	}
+	progress.Stop()
+
+	res.Finish()
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if path := v.GetString("report"); path != "" {
+		if err := res.WriteJSON(path); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
+
+// check returns why the approved group is not executed, the files are to be as they were planned
+func check(g *Group) string {
+	if g.Kept() == 0 {
+		return "no file of the group is kept"
+	}
+	for _, f := range g.Files {
+		if f.Action != ActionKeep && f.Action != ActionRemove {
+			return fmt.Sprintf("invalid action %q", f.Action)
+		}
+		if !filepath.IsAbs(f.Path) {
+			return "not an absolute path"
+		}
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			return fmt.Sprintf("%s: %s", f.Path, err)
+		}
+		if info.Size() != g.Size {
+			return fmt.Sprintf("%s changed since planned", f.Path)
+		}
+		if f.SHA256 == "" {
+			return fmt.Sprintf("no checksum of %s planned", f.Path)
+		}
+	}
+
+	// by the contents at last, a file replaced by another of the same size is not the one reviewed
+	for _, f := range g.Files {
+		sum, err := sumFile(f.Path)
+		if err != nil {
+			return fmt.Sprintf("%s: %s", f.Path, err)
+		}
+		if sum != f.SHA256 {
+			return fmt.Sprintf("%s changed since planned", f.Path)
+		}
+	}
+	return ""
+}
+
+func sumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -12,6 +12,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
 	"github.com/enjoypi/gojob"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -26,10 +27,35 @@ type config struct {
 }
 
 func Run(v *viper.Viper, args []string) error {
+	if plan := v.GetString("plan"); plan != "" {
+		return runPlan(v, plan)
+	}
+
+	cfg := loadConfig()
+
+	progress.Start("tidy")
+	defer progress.Stop()
+
+	idx := index.NewEmptyIndex()
+	for _, arg := range args {
+
+		if err := idx.Walk(arg, cfg.Ignored); err != nil {
+			return err
+		}
+	}
+
+	findSame(idx, func(size int64, same []string) {
+		logRM(same, &cfg)
+	})
+	return nil
+}
+
+func loadConfig() config {
 	var cfg config
 
 	f, err := os.Open(configFile)
 	if err == nil {
+		defer f.Close()
 		d := yaml.NewDecoder(f)
 		if err := d.Decode(&cfg); err != nil {
 			zap.L().Error(err.Error())
@@ -37,20 +63,17 @@ func Run(v *viper.Viper, args []string) error {
 	} else {
 		zap.L().Info(configFile, zap.Error(err))
 	}
+	return cfg
+}
 
-	idx := index.NewEmptyIndex()
-	for _, arg := range args {
-
-		if err := idx.Walk(arg, cfg.Ignored); err != nil {
-			return err
-		}
-	}
-
+// findSame calls fn with every group of the same media of a size, concurrently
+func findSame(idx *index.Index, fn func(size int64, same []string)) {
 	files := idx.GetMediaBySize()
 	keys := make([]int, 0)
 	for k, hashes := range files {
 		if len(hashes) > 1 {
 			keys = append(keys, int(k))
+			progress.AddTotal(int64(len(hashes)), k*int64(len(hashes)))
 		}
 	}
 	sort.Ints(keys)
@@ -63,44 +86,44 @@ func Run(v *viper.Viper, args []string) error {
 			zap.L().Debug("started", zap.Int32("taskID", id), zap.Int64("size", size))
 
 			media := files[size]
-			same := sameMedia(media)
-			if len(same) > 0 {
-				logRM(same, &cfg)
+			for _, same := range sameMedia(media) {
+				fn(size, same)
 			}
+			progress.Add(progress.Processed, int64(len(media)), size*int64(len(media)))
 			return nil
 		}, values, nil)
 	}
 
 	m.Wait()
-
-	return nil
 }
 
-func sameMedia(media index.Media) []string {
-	same := make([]string, 0)
-	var found bool
+// sameMedia groups the media of a size by the first of each group they are the same as
+func sameMedia(media index.Media) [][]string {
+	var groups [][]string
+	grouped := make([]bool, len(media))
 	for i := 0; i < len(media)-1; i++ {
+		if grouped[i] {
+			continue
+		}
 		lhs := media[i]
+		same := []string{lhs.FullPath}
 		for j := i + 1; j < len(media); j++ {
 			rhs := media[j]
-			if os.SameFile(lhs.FileInfo, rhs.FileInfo) {
+			if grouped[j] || os.SameFile(lhs.FileInfo, rhs.FileInfo) {
 				continue
 			}
 
 			if lhs.Same(rhs) {
-				if !found {
-					same = append(same, lhs.FullPath)
-					found = true
-				}
 				same = append(same, rhs.FullPath)
+				grouped[j] = true
 			}
 		}
-		if found {
-			break
+		if len(same) > 1 {
+			groups = append(groups, same)
 		}
 	}
 
-	return same
+	return groups
 }
 
 func logDupFiles(hashes []*index.Medium) {
@@ -148,16 +171,19 @@ func (p customSlice) Less(i, j int) bool {
 }
 func (p customSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 
-func logRM(files []string, cfg *config) {
+// propose sorts files and returns the one to remove, the first in path2rm or else the shortest
+func propose(files []string, cfg *config) int {
 	sort.Sort(customSlice(files))
-	// rm shortest path
-	rm := 0
 	for i := 0; i < len(files); i++ {
 		if match(files[i], cfg.Path2rm) {
-			rm = i
-			break
+			return i
 		}
 	}
+	return 0
+}
+
+func logRM(files []string, cfg *config) {
+	rm := propose(files, cfg)
 	buf := bytes.NewBufferString("")
 	for i := 0; i < len(files); i++ {
 		if i != rm {
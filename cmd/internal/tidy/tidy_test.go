@@ -0,0 +1,46 @@
+package tidy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/enjoypi/bkpic/index"
+)
+
+func TestSameMedia(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two groups and a single of the same size
+	contents := map[string]string{
+		"a1.bin": "aaaaaaaa",
+		"b1.bin": "bbbbbbbb",
+		"a2.bin": "aaaaaaaa",
+		"c.bin":  "cccccccc",
+		"b2.bin": "bbbbbbbb",
+		"a3.bin": "aaaaaaaa",
+	}
+	var media index.Media
+	for _, name := range []string{"a1.bin", "b1.bin", "a2.bin", "c.bin", "b2.bin", "a3.bin"} {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(contents[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		media = append(media, index.NewMedium(file))
+	}
+
+	got := sameMedia(media)
+	want := [][]string{
+		{filepath.Join(dir, "a1.bin"), filepath.Join(dir, "a2.bin"), filepath.Join(dir, "a3.bin")},
+		{filepath.Join(dir, "b1.bin"), filepath.Join(dir, "b2.bin")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sameMedia() = %v, want %v", got, want)
+	}
+}
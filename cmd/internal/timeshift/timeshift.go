@@ -0,0 +1,191 @@
+package timeshift
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+const (
+	outcomeShifted    report.Outcome = "shifted"
+	outcomeWouldShift report.Outcome = "would-shift"
+	outcomeSkipped    report.Outcome = "skipped"
+)
+
+// exiftool shifts the EXIF dates and the QuickTime dates, and keeps the file dates
+var exiftoolFlags = []string{"-charset", "filename=UTF8", "-overwrite_original", "-P", "-q"}
+
+type Config struct {
+	DryRun bool `mapstructure:"dry-run"`
+	Offset time.Duration
+	Model  string
+	Serial string
+	From   string
+	To     string
+}
+
+// Run rewrites the dates of the media in args by the offset, if they match the camera and the range.
+func Run(c *Config, args []string) error {
+	if c.Offset == 0 {
+		return fmt.Errorf("no offset to shift")
+	}
+
+	selector := &index.ClockCorrection{Model: c.Model, Serial: c.Serial, From: c.From, To: c.To, Offset: c.Offset}
+	if err := selector.Init(); err != nil {
+		return err
+	}
+
+	shifted := outcomeShifted
+	if c.DryRun {
+		shifted = outcomeWouldShift
+	}
+	res := report.New("timeshift", shifted, outcomeSkipped)
+	var files []string
+	for _, arg := range args {
+		idx, err := index.NewIndex(arg)
+		if err != nil {
+			res.Fail(arg, err.Error())
+			continue
+		}
+		if !fs.IsLocal(idx.Storage()) {
+			// exiftool rewrites local files only
+			res.Fail(arg, "not a local directory")
+			continue
+		}
+		if err := idx.LoadMeta(); err != nil {
+			res.Fail(arg, err.Error())
+			continue
+		}
+
+		for _, media := range idx.GetMediaBySize() {
+			for _, m := range media {
+				if !selected(selector, m) {
+					res.Add(outcomeSkipped, 1)
+					continue
+				}
+
+				shooting := m.ShootingTime()
+				zap.L().Info(fmt.Sprintf("%s\t%s\t=>\t%s", m.FullPath,
+					shooting.Format(time.RFC3339), shooting.Add(c.Offset).Format(time.RFC3339)))
+				files = append(files, m.FullPath)
+			}
+		}
+	}
+
+	n := len(files)
+	if n > 0 && !c.DryRun {
+		failed := shift(files, c.Offset)
+		for _, f := range files {
+			if err, ok := failed[f]; ok {
+				res.Fail(f, err.Error())
+				n--
+			}
+		}
+	}
+	res.Add(shifted, n)
+
+	res.Finish()
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// selected reports whether the camera dates of m match selector
+func selected(selector *index.ClockCorrection, m *index.Medium) bool {
+	if !m.Valid() {
+		return false
+	}
+
+	shooting := m.ShootingTime()
+	switch shooting.Source {
+	case index.SourceDateTimeOriginal, index.SourceCreateDate, index.SourceQuickTime:
+	default:
+		// no camera dates to rewrite
+		return false
+	}
+
+	// the configured corrections are not in the file
+	return selector.Match(m.Meta(), shooting.Add(-shooting.Correction))
+}
+
+// shift rewrites the dates of files by one exiftool, and returns the errors of the files not rewritten
+func shift(files []string, offset time.Duration) map[string]error {
+	op, value := "+=", shiftValue(offset)
+	if offset < 0 {
+		op, value = "-=", shiftValue(-offset)
+	}
+
+	args := append(exiftoolFlags, "-AllDates"+op+value, "-QuickTime:Time:All"+op+value, "-@", "-")
+	cmd := exec.Command("exiftool", args...)
+	list := new(bytes.Buffer)
+	for _, f := range files {
+		list.WriteString(f)
+		list.WriteByte('\n')
+	}
+	cmd.Stdin = list
+	zap.L().Debug(cmd.String())
+
+	out, err := cmd.CombinedOutput()
+	failed := fileErrors(out, files)
+	if err != nil && len(failed) == 0 {
+		// exiftool failed before any file
+		err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+		for _, f := range files {
+			failed[f] = err
+		}
+	}
+	return failed
+}
+
+// fileErrors returns the errors of files in the output of exiftool, each is like "Error: message - file"
+func fileErrors(out []byte, files []string) map[string]error {
+	known := make(map[string]bool, len(files))
+	for _, f := range files {
+		known[f] = true
+	}
+
+	failed := make(map[string]error)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, "Error: ") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "Error: ")
+		// the file name may have " - " too
+		for i := strings.Index(line, " - "); i >= 0; {
+			if f := line[i+3:]; known[f] {
+				failed[f] = errors.New(line[:i])
+				break
+			}
+			next := strings.Index(line[i+3:], " - ")
+			if next < 0 {
+				break
+			}
+			i += 3 + next
+		}
+	}
+	return failed
+}
+
+// shiftValue formats the offset as the shift of exiftool, Y:M:D H:M:S
+func shiftValue(offset time.Duration) string {
+	days := offset / (24 * time.Hour)
+	offset -= days * 24 * time.Hour
+	hours := offset / time.Hour
+	offset -= hours * time.Hour
+	minutes := offset / time.Minute
+	offset -= minutes * time.Minute
+	seconds := offset / time.Second
+	return fmt.Sprintf("0:0:%d %d:%d:%d", days, hours, minutes, seconds)
+}
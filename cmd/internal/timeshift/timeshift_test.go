@@ -0,0 +1,30 @@
+package timeshift
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileErrors(t *testing.T) {
+	files := []string{"/photos/a.jpg", "/photos/b - copy.jpg", "/photos/c.mov"}
+	out := []byte("Warning: [minor] Entries in IFD0 were out of sequence. Fixed. - /photos/a.jpg\n" +
+		"Error: Not a valid JPG (looks more like a PNG) - /photos/b - copy.jpg\r\n" +
+		"Error: File not found - /photos/missing.jpg\n")
+
+	failed := fileErrors(out, files)
+	got := make(map[string]string)
+	for f, err := range failed {
+		got[f] = err.Error()
+	}
+	want := map[string]string{"/photos/b - copy.jpg": "Not a valid JPG (looks more like a PNG)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fileErrors() = %v, want %v", got, want)
+	}
+}
+
+func TestShiftValue(t *testing.T) {
+	if got := shiftValue(26*time.Hour + 3*time.Minute + 4*time.Second); got != "0:0:1 2:3:4" {
+		t.Errorf("shiftValue() = %s", got)
+	}
+}
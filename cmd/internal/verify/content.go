@@ -0,0 +1,154 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxFtypSize is far more than the brands of any file, a larger ftyp is skipped as unknown
+const maxFtypSize = 4096
+
+// the top-level boxes which may begin a QuickTime or ISO media file
+var leadingBoxes = map[string]bool{"ftyp": true, "moov": true, "mdat": true, "wide": true, "free": true, "skip": true}
+
+// checkContent decodes the images and parses the boxes of the ISO media containers like MP4 and MOV,
+// other formats are not checked.
+func checkContent(r io.Reader) error {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+
+	switch http.DetectContentType(head) {
+	case "image/jpeg", "image/png", "image/gif":
+		_, _, err := image.Decode(br)
+		return err
+	}
+
+	if len(head) >= 8 && leadingBoxes[string(head[4:8])] {
+		return checkBoxes(br)
+	}
+	return nil
+}
+
+// the brands of ftyp whose files keep their data in a meta box, the images of HEIF like HEIC and AVIF
+var imageBrands = map[string]bool{
+	"mif1": true, "msf1": true, "miaf": true,
+	"heic": true, "heix": true, "heim": true, "heis": true, "hevc": true, "hevx": true,
+	"avif": true, "avis": true,
+}
+
+// brandBox returns the box required by a brand of ftyp, empty for the brands unknown
+func brandBox(brand string) string {
+	switch {
+	case imageBrands[brand]:
+		return "meta"
+	case brand == "qt  ", brand == "M4V ", brand == "isom",
+		strings.HasPrefix(brand, "iso"), strings.HasPrefix(brand, "mp4"),
+		strings.HasPrefix(brand, "3gp"), strings.HasPrefix(brand, "3g2"):
+		return "moov"
+	}
+	return ""
+}
+
+// requiredBox returns the box required by the payload of ftyp, by its major brand first, then the compatible ones
+func requiredBox(ftyp []byte) string {
+	if len(ftyp) < 4 {
+		return ""
+	}
+	if box := brandBox(string(ftyp[:4])); box != "" {
+		return box
+	}
+
+	var compatible []string
+	for i := 8; i+4 <= len(ftyp); i += 4 {
+		compatible = append(compatible, string(ftyp[i:i+4]))
+	}
+	for _, brand := range compatible {
+		if imageBrands[brand] {
+			return "meta"
+		}
+	}
+	for _, brand := range compatible {
+		if box := brandBox(brand); box != "" {
+			return box
+		}
+	}
+	return ""
+}
+
+// checkBoxes reads the top-level boxes to the end, they must fill the file and include the box of the data:
+// the movie box of QuickTime and MP4, the meta box of HEIF images.
+// A file without ftyp is of the old QuickTime.
+func checkBoxes(r io.Reader) error {
+	required := "moov"
+	seen := make(map[string]bool)
+	first := true
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("truncated box header: %w", err)
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// the last box extends to the end
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				return err
+			}
+			seen[typ] = true
+			size = -1
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return fmt.Errorf("truncated box header: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < 0 {
+			break
+		}
+		if size < headerSize {
+			return fmt.Errorf("invalid size %d of box %q", size, typ)
+		}
+
+		if first && typ == "ftyp" {
+			required = ""
+		}
+		if first && typ == "ftyp" && size-headerSize <= maxFtypSize {
+			payload := make([]byte, size-headerSize)
+			if n, err := io.ReadFull(r, payload); err != nil {
+				return fmt.Errorf("truncated box %q: %d of %d bytes", typ, int64(n)+headerSize, size)
+			}
+			required = requiredBox(payload)
+		} else if n, err := io.CopyN(ioutil.Discard, r, size-headerSize); err != nil {
+			return fmt.Errorf("truncated box %q: %d of %d bytes", typ, n+headerSize, size)
+		}
+		seen[typ] = true
+		first = false
+	}
+
+	switch {
+	case required == "":
+		return nil
+	case !seen[required] && required == "moov":
+		return errors.New("no movie box")
+	case !seen[required]:
+		return fmt.Errorf("no %s box", required)
+	}
+	return nil
+}
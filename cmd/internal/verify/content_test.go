@@ -0,0 +1,71 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// box is a top-level box of an ISO media file
+func box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+// ftyp is the payload of a file type box: the major brand, the minor version and the compatible brands
+func ftyp(major string, compatible ...string) []byte {
+	b := append([]byte(major), 0, 0, 0, 0)
+	for _, brand := range compatible {
+		b = append(b, brand...)
+	}
+	return box("ftyp", b)
+}
+
+func file(boxes ...[]byte) []byte {
+	return bytes.Join(boxes, nil)
+}
+
+func TestCheckContentBoxes(t *testing.T) {
+	data := bytes.Repeat([]byte{0xab}, 64)
+	// the handler and item boxes of a HEIF meta, enough to be a box of the right shape
+	meta := box("meta", []byte{0, 0, 0, 0}, box("hdlr", make([]byte, 24)), box("pitm", make([]byte, 6)))
+	moov := box("moov", box("mvhd", make([]byte, 100)))
+
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"mp4", file(ftyp("isom", "isom", "iso2", "avc1", "mp41"), moov, box("mdat", data)), true},
+		{"mp4 moov last", file(ftyp("mp42", "mp42", "isom"), box("free"), box("mdat", data), moov), true},
+		{"mp4 without moov", file(ftyp("isom", "isom", "mp41"), box("mdat", data)), false},
+		{"mov", file(ftyp("qt  ", "qt  "), box("wide"), box("mdat", data), moov), true},
+		{"mov without ftyp", file(box("wide"), box("mdat", data), moov), true},
+		{"mov without ftyp and moov", file(box("wide"), box("mdat", data)), false},
+		{"m4v", file(ftyp("M4V ", "M4V ", "M4A ", "mp42", "isom"), moov, box("mdat", data)), true},
+		{"3gp", file(ftyp("3gp4", "isom", "3gp4"), moov, box("mdat", data)), true},
+		{"heic", file(ftyp("heic", "mif1", "heic"), meta, box("mdat", data)), true},
+		{"heic of mif1", file(ftyp("mif1", "mif1", "heic", "miaf"), meta, box("mdat", data)), true},
+		{"heic sequence", file(ftyp("msf1", "msf1", "hevc", "iso8"), meta, moov, box("mdat", data)), true},
+		{"avif", file(ftyp("avif", "avif", "mif1", "miaf", "MA1A"), meta, box("mdat", data)), true},
+		{"heic without meta", file(ftyp("heic", "mif1", "heic"), box("mdat", data)), false},
+		{"unknown brand", file(ftyp("crx ", "crx "), box("uuid", data), box("mdat", data)), true},
+		{"unknown brand of heif", file(ftyp("abcd", "iso8", "mif1"), meta, box("mdat", data)), true},
+		{"truncated mdat", file(ftyp("isom", "isom"), moov, box("mdat", data))[:150], false},
+		{"truncated heic", file(ftyp("heic", "mif1", "heic"), meta, box("mdat", data))[:100], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkContent(bytes.NewReader(tt.data))
+			if tt.ok && err != nil {
+				t.Errorf("checkContent() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("checkContent() = nil, want an error")
+			}
+		})
+	}
+}
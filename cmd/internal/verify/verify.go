@@ -0,0 +1,281 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
+	"github.com/enjoypi/bkpic/store"
+)
+
+const (
+	outcomeVerified report.Outcome = "verified"
+	outcomeAdded    report.Outcome = "added"
+	outcomeNotDue   report.Outcome = "skipped-not-due"
+
+	// the problems found
+	problemMissing    = "missing"
+	problemModified   = "modified"
+	problemCorrupted  = "corrupted"
+	problemUnexpected = "unexpected"
+
+	snapshotsDir = "snapshots"
+)
+
+type Config struct {
+	// Sample is the number of files verified at random, all if 0
+	Sample int
+	// Days verifies only the files not verified in the days, all if 0
+	Days int
+	// Add puts the unexpected files to the manifest by their current checksums
+	Add    bool
+	Report string
+}
+
+// Run verifies the files of the cp output or the backup store at location against the checksums kept by them.
+func Run(c *Config, location string) error {
+	s, root, err := fs.Parse(location)
+	if err != nil {
+		return err
+	}
+	if fs.IsLocal(s) {
+		if root, err = filepath.Abs(root); err != nil {
+			return err
+		}
+	}
+
+	mf, err := index.LoadManifest(s, root)
+	if err != nil {
+		return err
+	}
+	expected, isStore, err := expectedFiles(s, root, location, mf)
+	if err != nil {
+		return err
+	}
+	present, err := presentFiles(s, root)
+	if err != nil {
+		return err
+	}
+
+	progress.Start("verify")
+	defer progress.Stop()
+
+	res := report.New("verify", outcomeVerified, outcomeAdded, outcomeNotDue)
+	problem := func(rel string, kind string, detail string) {
+		res.Tally("problems", kind)
+		if detail != "" {
+			kind += ": " + detail
+		}
+		res.Fail(fs.Join(s, root, rel), kind)
+	}
+
+	var due []string
+	now := time.Now()
+	for rel, e := range expected {
+		if _, ok := present[rel]; !ok {
+			problem(rel, problemMissing, "")
+			continue
+		}
+		if c.Days > 0 && e.Verified != nil && now.Sub(*e.Verified) < time.Duration(c.Days)*24*time.Hour {
+			res.Add(outcomeNotDue, 1)
+			continue
+		}
+		due = append(due, rel)
+	}
+	sort.Strings(due)
+	if c.Sample > 0 && c.Sample < len(due) {
+		rand.Seed(now.UnixNano())
+		rand.Shuffle(len(due), func(i, j int) { due[i], due[j] = due[j], due[i] })
+		res.Add(outcomeNotDue, len(due)-c.Sample)
+		due = due[:c.Sample]
+		sort.Strings(due)
+	}
+
+	var unexpected []string
+	for rel := range present {
+		if _, ok := expected[rel]; !ok {
+			unexpected = append(unexpected, rel)
+		}
+	}
+	sort.Strings(unexpected)
+
+	var size int64
+	for _, rel := range due {
+		size += present[rel].Size()
+	}
+	progress.AddTotal(int64(len(due)), size)
+
+	for _, rel := range due {
+		e, info := expected[rel], present[rel]
+		kind, detail := verifyFile(s, fs.Join(s, root, rel), e, info)
+		if kind != "" {
+			problem(rel, kind, detail)
+		} else {
+			verified := now
+			e.Verified = &verified
+			mf.Files[rel] = e
+			res.Add(outcomeVerified, 1)
+		}
+		progress.Add(progress.Processed, 1, info.Size())
+	}
+
+	for _, rel := range unexpected {
+		// objects of a store out of any snapshot are never added
+		if !c.Add || isStore {
+			problem(rel, problemUnexpected, "")
+			continue
+		}
+		file := fs.Join(s, root, rel)
+		if err := checkFile(s, file); err != nil {
+			problem(rel, problemCorrupted, err.Error())
+			continue
+		}
+		sum, err := sumFile(s, file)
+		if err != nil {
+			problem(rel, problemUnexpected, err.Error())
+			continue
+		}
+		info := present[rel]
+		mf.Files[rel] = &index.ManifestEntry{SHA256: sum, Size: info.Size(), ModTime: info.ModTime()}
+		res.Add(outcomeAdded, 1)
+	}
+
+	if err := mf.Save(); err != nil {
+		zap.L().Info("failed to save manifest", zap.String("location", location), zap.Error(err))
+	}
+	progress.Stop()
+
+	res.Finish()
+	if err := res.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if c.Report != "" {
+		if err := res.WriteJSON(c.Report); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
+
+// expectedFiles returns the objects of the snapshots in a backup store, or the files in the manifest of a cp output
+func expectedFiles(s fs.Storage, root string, location string, mf *index.Manifest) (map[string]*index.ManifestEntry, bool, error) {
+	st, err := store.Open(location)
+	if err != nil {
+		return nil, false, err
+	}
+	ids, err := st.Snapshots()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(ids) == 0 {
+		return mf.Files, false, nil
+	}
+
+	expected := make(map[string]*index.ManifestEntry)
+	for _, id := range ids {
+		snap, err := st.LoadSnapshot(id)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, f := range snap.Files {
+			sum, err := hex.DecodeString(f.SHA256)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid checksum %q in snapshot %s", f.SHA256, id)
+			}
+			rel, err := fs.Rel(s, root, st.ObjectPath(sum))
+			if err != nil {
+				return nil, false, err
+			}
+			// the content of an object never changes, only the verified time is kept in the manifest
+			e := &index.ManifestEntry{SHA256: f.SHA256, Size: f.Size}
+			if old, ok := mf.Files[rel]; ok {
+				e.Verified = old.Verified
+			}
+			expected[rel] = e
+		}
+	}
+	return expected, true, nil
+}
+
+// presentFiles returns the files under root by their relative paths, except the ones of bkpic itself
+func presentFiles(s fs.Storage, root string) (map[string]os.FileInfo, error) {
+	present := make(map[string]os.FileInfo)
+	err := fs.Walk(s, root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := fs.Rel(s, root, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == index.MetaDir || rel == snapshotsDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		present[rel] = info
+		return nil
+	})
+	return present, err
+}
+
+// verifyFile returns the kind of problem of file and its detail, or empty strings if it is intact
+func verifyFile(s fs.Storage, file string, e *index.ManifestEntry, info os.FileInfo) (string, string) {
+	// a file rewritten since is modified, objects of a store have no modification time to compare
+	if !e.ModTime.IsZero() && !info.ModTime().Truncate(time.Second).Equal(e.ModTime.Truncate(time.Second)) {
+		return problemModified, fmt.Sprintf("modified at %s", info.ModTime().Format(time.RFC3339))
+	}
+	if info.Size() != e.Size {
+		return problemCorrupted, fmt.Sprintf("size %d, expect %d", info.Size(), e.Size)
+	}
+
+	sum, err := sumFile(s, file)
+	if err != nil {
+		return problemCorrupted, err.Error()
+	}
+	if !strings.EqualFold(sum, e.SHA256) {
+		return problemCorrupted, "checksum mismatch"
+	}
+
+	if err := checkFile(s, file); err != nil {
+		return problemCorrupted, err.Error()
+	}
+	return "", ""
+}
+
+func checkFile(s fs.Storage, file string) error {
+	r, err := s.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return checkContent(r)
+}
+
+func sumFile(s fs.Storage, file string) (string, error) {
+	r, err := s.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/repair"
+	"github.com/enjoypi/bkpic/parity"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	parityCmd := &cobra.Command{
+		Use:     "parity <directory>...",
+		Short:   "generate the parity of every directory whose files changed, to repair them later",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c repair.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return repair.Protect(&c, args)
+		},
+		Args: cobra.MinimumNArgs(1),
+	}
+	flags := parityCmd.Flags()
+	flags.Int("parity.redundancy", 10, "the size of parity in percent of the data of each directory")
+	flags.Int("parity.block", parity.DefaultBlockSize, "the block size of parity, the unit of damage and repair")
+	flags.BoolP("dry-run", "n", false, "list the directories to update without writing parity")
+	flags.String("report", "", "write the run result as JSON to the file")
+	rootCmd.AddCommand(parityCmd)
+
+	repairCmd := &cobra.Command{
+		Use:     "repair <directory>...",
+		Short:   "check the files of every directory by their parity and reconstruct the damaged ones",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c repair.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return repair.Run(&c, args)
+		},
+		Args: cobra.MinimumNArgs(1),
+	}
+	flags = repairCmd.Flags()
+	flags.BoolP("dry-run", "n", false, "list the damaged files without repairing them")
+	flags.String("report", "", "write the run result as JSON to the file")
+	rootCmd.AddCommand(repairCmd)
+}
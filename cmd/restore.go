@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/restore"
+	"github.com/enjoypi/bkpic/store"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "restore <backup store or cp output>",
+		Short:   "restore the media of a backup snapshot or a cp output with their names and modification times",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c restore.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return restore.Run(&c, args[0])
+		},
+		Args: cobra.ExactArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.StringP("output", "o", "", "the target directory, or s3://bucket/prefix, sftp://host/path and webdav://host/path")
+	flags.String("snapshot", store.Latest, "the ID of the snapshot to restore from a backup store")
+	flags.String("glob", "", "restore the files whose names match the glob, or their relative paths if it has /")
+	flags.String("since", "", "restore the media shot on or after the date in the zone of shooting, like 2006-01-02")
+	flags.String("until", "", "restore the media shot on or before the date in the zone of shooting, like 2006-01-02")
+	flags.String("model", "", "restore the media of the camera model")
+	flags.String("report", "", "write the run result as JSON to the file")
+	flags.BoolP("dry-run", "n", false, "list the files to restore without writing them")
+
+	_ = subCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(subCmd)
+}
@@ -1,18 +1,34 @@
 package cmd
 
 import (
+	"errors"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/enjoypi/bkpic/cmd/internal/report"
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/geo"
+	"github.com/enjoypi/bkpic/index"
+	"github.com/enjoypi/bkpic/progress"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
 )
 
+// exit codes, so that scheduled runs can alert
+const (
+	exitFatal          = 1
+	exitPartialFailure = 2
+)
+
 var (
 	configFile string
 	configType string
 	logLevel   string
+	timezone   string
+	geoCities  string
 	rootViper  = viper.New()
 )
 
@@ -34,9 +50,17 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		zap.L().Info(err.Error())
+	err := rootCmd.Execute()
+	if err == nil {
+		return
+	}
+
+	rootCmd.PrintErrln("Error:", err.Error())
+	_ = zap.L().Sync()
+	if errors.Is(err, report.ErrPartialFailure) {
+		os.Exit(exitPartialFailure)
 	}
+	os.Exit(exitFatal)
 }
 
 func init() {
@@ -50,6 +74,18 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log.level", "info", "level of zap")
 
+	rootCmd.PersistentFlags().StringVar(&geoCities, "geo.cities", "", "the cities file of GeoNames for reverse geocoding, like cities15000.txt, instead of the bundled cities; "+
+		"the bundled are about 230 major cities, and name no place farther than 200km from them")
+
+	rootCmd.PersistentFlags().String("s3.endpoint", "s3.amazonaws.com", "the endpoint of s3:// locations, like 127.0.0.1:9000 of MinIO")
+	rootCmd.PersistentFlags().String("s3.region", "us-east-1", "the region of s3:// locations")
+	rootCmd.PersistentFlags().Bool("s3.insecure", false, "connect to s3:// locations by HTTP")
+
+	rootCmd.PersistentFlags().String("sftp.ssh", "ssh", "the ssh command of sftp:// locations, with the config and the keys of the user")
+	rootCmd.PersistentFlags().Bool("webdav.insecure", false, "connect to webdav:// locations by HTTP")
+
+	rootCmd.PersistentFlags().StringVar(&timezone, "timezone", "Local", "the zone of shooting times without offset, like Asia/Tokyo")
+
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().Bool("version", false, "show version")
@@ -70,7 +106,15 @@ func preRunE(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	zap.ReplaceGlobals(logger)
+	// the log entries and the progress bar share stderr
+	zap.ReplaceGlobals(logger.WithOptions(zap.WrapCore(progress.WrapCore)))
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return err
+	}
+	index.DefaultLocation = loc
+	geo.SetCitiesFile(geoCities)
 
 	// Viper uses the following precedence order. Each item takes precedence over the item below it:
 	//
@@ -99,6 +143,14 @@ func preRunE(cmd *cobra.Command, args []string) error {
 		zap.S().Debug("local settings: ", v.AllSettings())
 	}
 
+	var corrections []*index.ClockCorrection
+	if err := v.UnmarshalKey("clock", &corrections); err != nil {
+		return err
+	}
+	if err := index.SetClockCorrections(corrections); err != nil {
+		return err
+	}
+
 	// env
 	v.AutomaticEnv() // read in environment variables that match
 
@@ -107,6 +159,19 @@ func preRunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// the keys of s3 are AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, the password of webdav is WEBDAV_PASSWORD, or in config
+	var storages struct {
+		S3     fs.S3Config
+		SFTP   fs.SFTPConfig
+		WebDAV fs.WebDAVConfig
+	}
+	if err := v.Unmarshal(&storages); err != nil {
+		return err
+	}
+	fs.SetS3Config(storages.S3)
+	fs.SetSFTPConfig(storages.SFTP)
+	fs.SetWebDAVConfig(storages.WebDAV)
+
 	showConfig(v)
 	return nil
 }
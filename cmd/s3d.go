@@ -0,0 +1,30 @@
+//go:build s3d
+// +build s3d
+
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/internal/s3d"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "s3d <directory>",
+		Short:   "serve a directory as a local S3-compatible storage for testing, its subdirectories are the buckets",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c s3d.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return s3d.Run(&c, args[0])
+		},
+		Args: cobra.ExactArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.String("addr", "127.0.0.1:9000", "the address to listen")
+
+	rootCmd.AddCommand(subCmd)
+}
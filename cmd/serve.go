@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/serve"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "serve <directory>...",
+		Short:   "review the duplicates of tidy in a local web UI, the decisions are saved as a plan for tidy --plan",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c serve.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return serve.Run(&c, args)
+		},
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.String("listen", "127.0.0.1:8080", "the address of the web UI")
+	flags.String("plan", "bkpic-plan.json", "the action plan file, the decisions in it are kept for the same groups")
+	flags.String("cache", "", "the directory of thumbnails, the cache directory of the user if empty")
+
+	rootCmd.AddCommand(subCmd)
+}
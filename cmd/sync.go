@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/archivesync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "sync <A> <B>",
+		Short:   "sync two archives by their contents, renaming the moved files instead of copying them",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c archivesync.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return archivesync.Run(&c, args[0], args[1])
+		},
+		Args: cobra.ExactArgs(2),
+	}
+
+	flags := subCmd.Flags()
+	flags.Bool("mirror", false, "make B the same as A, otherwise the changes of both sides since the last sync are synced")
+	flags.BoolP("dry-run", "n", false, "print the plan without changing the archives")
+	flags.String("report", "", "write the run result as JSON to the file")
+
+	rootCmd.AddCommand(subCmd)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/thumbs"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "thumbs <directory or archive>...",
+		Short:   "build the thumbnails of images and the poster frames of videos into the cache by their contents",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c thumbs.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return thumbs.Run(&c, args)
+		},
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.String("cache", "", "the directory of thumbnails, the cache directory of the user if empty")
+	flags.String("report", "", "write the run result as JSON to the file")
+
+	rootCmd.AddCommand(subCmd)
+}
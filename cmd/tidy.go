@@ -7,16 +7,27 @@ import (
 
 // doCmd represents the do command
 var tidyCmd = &cobra.Command{
-	Use:     "tidy",
+	Use:     "tidy <directory>... | tidy --plan <file>",
 	Short:   "tidy media to output directory",
 	PreRunE: preRunE,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return tidy.Run(rootViper, args)
 	},
-	Args: cobra.MinimumNArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		// the directories of a plan are in the plan
+		if plan, _ := cmd.Flags().GetString("plan"); plan != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
 }
 
 func init() {
+	flags := tidyCmd.Flags()
+	flags.String("plan", "", "remove the files of the approved groups of the action plan saved by serve")
+	flags.BoolP("dry-run", "n", false, "print the files of the plan to remove without removing them")
+	flags.String("report", "", "write the run result of the plan as JSON to the file")
+
 	rootCmd.AddCommand(tidyCmd)
 
 }
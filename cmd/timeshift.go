@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/timeshift"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "timeshift",
+		Short:   "shift the EXIF dates of media taken with a wrong camera clock",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c timeshift.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return timeshift.Run(&c, args)
+		},
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.BoolP("dry-run", "n", false, "show the shifted times without rewriting files")
+	flags.Duration("offset", 0, "the offset added to the dates, like -1h30m")
+	flags.String("model", "", "only the media of the camera model")
+	flags.String("serial", "", "only the media of the camera serial number")
+	flags.String("from", "", "only the media shot at or after the time, as 2006-01-02 or RFC 3339")
+	flags.String("to", "", "only the media shot before the time, as 2006-01-02 or RFC 3339")
+
+	_ = subCmd.MarkFlagRequired("offset")
+	rootCmd.AddCommand(subCmd)
+}
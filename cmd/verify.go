@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/enjoypi/bkpic/cmd/internal/verify"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subCmd := &cobra.Command{
+		Use:     "verify <cp output or backup store>",
+		Short:   "find the missing, modified, corrupted and unexpected files by their kept checksums",
+		PreRunE: preRunE,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var c verify.Config
+			if err := rootViper.Unmarshal(&c); err != nil {
+				return err
+			}
+			return verify.Run(&c, args[0])
+		},
+		Args: cobra.ExactArgs(1),
+	}
+
+	flags := subCmd.Flags()
+	flags.Int("sample", 0, "verify the number of files at random, all files if 0")
+	flags.Int("days", 0, "verify only the files not verified in the days, all files if 0")
+	flags.Bool("add", false, "add the unexpected files to the manifest by their current checksums")
+	flags.String("report", "", "write the run result as JSON to the file")
+
+	rootCmd.AddCommand(subCmd)
+}
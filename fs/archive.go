@@ -0,0 +1,524 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errReadOnly = errors.New("read-only archive")
+
+// the extensions of archives by their formats
+var archiveExts = map[string]string{
+	".zip":     "zip",
+	".tar":     "tar",
+	".tar.gz":  "tar.gz",
+	".tgz":     "tar.gz",
+	".tar.bz2": "tar.bz2",
+	".tbz2":    "tar.bz2",
+}
+
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+	for ext, format := range archiveExts {
+		if strings.HasSuffix(name, ext) {
+			return format
+		}
+	}
+	return ""
+}
+
+// IsArchive reports whether name is a regular file of a supported archive format.
+func IsArchive(name string) bool {
+	if archiveFormat(name) == "" {
+		return false
+	}
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Archive is a read-only storage of the members in a zip or tar archive,
+// whose paths are the member names under the path of the archive file.
+// The members of zip are read in any order. A tar is streamed and never extracted to a temporary file:
+// its first Walk lists the members while they are read, and the members are read fastest in the order of Walk,
+// as an earlier member is read again from the beginning of the archive.
+type Archive struct {
+	file    string
+	format  string
+	info    os.FileInfo
+	entries map[string][]os.FileInfo
+	members map[string]*archiveMember
+	zip     *zip.ReadCloser
+
+	// order is the members of tar in the archive, listed is set when all of them are known
+	order  []string
+	listed bool
+	mu     sync.Mutex
+	// idle is the stream of tar not being read, which goes on to the later members
+	idle *tarStream
+}
+
+type archiveMember struct {
+	os.FileInfo
+	// seq is the number of the header in tar
+	seq int
+	zip *zip.File
+}
+
+// tarStream reads a tar from its beginning, it is at the header seq
+type tarStream struct {
+	file   *os.File
+	closer io.Closer
+	tr     *tar.Reader
+	seq    int
+	// fresh is set when the content of the member at seq is not read yet
+	fresh bool
+}
+
+// NewArchive opens the archive file, the members of zip are listed and the ones of tar are by the first Walk.
+func NewArchive(file string) (*Archive, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		file:    file,
+		format:  archiveFormat(file),
+		info:    archiveDir{name: filepath.Base(file), modTime: info.ModTime()},
+		entries: make(map[string][]os.FileInfo),
+		members: make(map[string]*archiveMember),
+	}
+
+	if a.format == "zip" {
+		if a.zip, err = zip.OpenReader(file); err != nil {
+			return nil, err
+		}
+		for _, f := range a.zip.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			a.add(f.Name, &archiveMember{FileInfo: f.FileInfo(), zip: f})
+		}
+		a.listed = true
+		return a, nil
+	}
+
+	// the compression is checked now, not by the first read
+	st, err := a.openTar()
+	if err != nil {
+		return nil, err
+	}
+	a.idle = st
+	return a, nil
+}
+
+// Sequential reports whether the members are read once in the order of Walk, like the ones of tar.
+func (a *Archive) Sequential() bool {
+	return a.zip == nil
+}
+
+func (a *Archive) openTar() (*tarStream, error) {
+	file, err := os.Open(a.file)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &tarStream{file: file, seq: -1}
+	var r io.Reader = file
+	switch a.format {
+	case "tar.gz":
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, &os.PathError{Op: "open", Path: a.file, Err: err}
+		}
+		r, st.closer = gr, gr
+	case "tar.bz2":
+		r = bzip2.NewReader(file)
+	}
+	st.tr = tar.NewReader(r)
+	return st, nil
+}
+
+// next goes to the next header of tar, io.EOF at the end
+func (st *tarStream) next() (*tar.Header, error) {
+	hdr, err := st.tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	st.seq++
+	st.fresh = true
+	return hdr, nil
+}
+
+func (st *tarStream) close() error {
+	if st.closer != nil {
+		st.closer.Close()
+	}
+	return st.file.Close()
+}
+
+// take takes the idle stream if it is not after the header seq, with the content of seq unread if unread is set,
+// or opens a new one
+func (a *Archive) take(seq int, unread bool) (*tarStream, error) {
+	a.mu.Lock()
+	st := a.idle
+	a.idle = nil
+	a.mu.Unlock()
+
+	if st != nil && (st.seq < seq || (st.seq == seq && (st.fresh || !unread))) {
+		return st, nil
+	}
+	if st != nil {
+		zap.L().Debug("read tar again", zap.String("archive", a.file), zap.Int("from", st.seq), zap.Int("to", seq))
+		st.close()
+	}
+	return a.openTar()
+}
+
+// seek goes on to the header seq
+func (st *tarStream) seek(seq int) error {
+	for st.seq < seq {
+		if _, err := st.next(); err != nil {
+			st.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// release keeps st to read the later members
+func (a *Archive) release(st *tarStream) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.idle == nil {
+		a.idle = st
+		return
+	}
+	st.close()
+}
+
+// list reads the headers of tar if no Walk listed them
+func (a *Archive) list() error {
+	if a.listed {
+		return nil
+	}
+	return a.walkTar(nil)
+}
+
+// Close closes the archive.
+func (a *Archive) Close() error {
+	var err error
+	if a.zip != nil {
+		err = a.zip.Close()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.idle != nil {
+		if e := a.idle.close(); err == nil {
+			err = e
+		}
+		a.idle = nil
+	}
+	return err
+}
+
+// add puts a member and its parent directories to the tree
+func (a *Archive) add(name string, m *archiveMember) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return
+	}
+	if old, ok := a.members[name]; ok {
+		// a later member of the same name replaces the earlier one in tar, and is read at its place
+		if old.IsDir() {
+			return
+		}
+		*old = *m
+		for i, o := range a.order {
+			if o == name {
+				a.order = append(a.order[:i], a.order[i+1:]...)
+				break
+			}
+		}
+		a.order = append(a.order, name)
+		return
+	}
+	a.members[name] = m
+	a.entries[path.Dir(name)] = append(a.entries[path.Dir(name)], m)
+	if m.zip == nil {
+		a.order = append(a.order, name)
+	}
+
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if _, ok := a.members[dir]; ok {
+			return
+		}
+		d := &archiveMember{FileInfo: archiveDir{name: path.Base(dir), modTime: m.ModTime()}}
+		a.members[dir] = d
+		a.entries[path.Dir(dir)] = append(a.entries[path.Dir(dir)], d)
+	}
+}
+
+// member returns the member name of p in the archive, "/" for the archive itself
+func (a *Archive) member(p string) (string, error) {
+	rel, err := filepath.Rel(a.file, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	return path.Clean("/" + filepath.ToSlash(rel)), nil
+}
+
+// Walk walks the members under root. The members of tar are walked in the archive, a member opened by fn
+// is read from the stream of the walk, so the first Walk of tar reads it once.
+func (a *Archive) Walk(root string, fn filepath.WalkFunc) error {
+	if a.zip != nil {
+		return walkTree(a, root, fn)
+	}
+
+	name, err := a.member(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if name != "/" {
+		// a directory in the archive is known by the listing
+		if err := a.list(); err != nil {
+			return fn(root, nil, err)
+		}
+	}
+	info, err := a.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if err := fn(root, info, nil); err != nil || !info.IsDir() {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	w := &tarWalk{archive: a, root: name, fn: fn, seen: map[string]bool{name: true}}
+	if a.listed {
+		for _, member := range append([]string(nil), a.order...) {
+			if err := w.visit(member); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return a.walkTar(w)
+}
+
+// walkTar lists the members by one pass of tar, visiting them by w if it is not nil
+func (a *Archive) walkTar(w *tarWalk) error {
+	// the listing starts from the first header
+	st, err := a.take(-1, false)
+	if err != nil {
+		return err
+	}
+
+	for {
+		hdr, err := st.next()
+		if err == io.EOF {
+			a.listed = true
+			a.release(st)
+			return nil
+		}
+		if err != nil {
+			st.close()
+			return &os.PathError{Op: "read", Path: a.file, Err: err}
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		a.add(hdr.Name, &archiveMember{FileInfo: hdr.FileInfo(), seq: st.seq})
+		if w == nil {
+			continue
+		}
+
+		// fn reads the member from st by Open, which gives it back by Close
+		seq := st.seq
+		a.release(st)
+		if err := w.visit(path.Clean("/" + hdr.Name)); err != nil {
+			return err
+		}
+		if st, err = a.take(seq, false); err != nil {
+			return err
+		}
+		if err := st.seek(seq); err != nil {
+			return &os.PathError{Op: "read", Path: a.file, Err: err}
+		}
+	}
+}
+
+// tarWalk calls fn with the members of tar in the archive, and their directories before them
+type tarWalk struct {
+	archive *Archive
+	root    string
+	fn      filepath.WalkFunc
+	// seen are the directories visited, skipped are the ones fn skipped
+	seen    map[string]bool
+	skipped []string
+}
+
+func (w *tarWalk) visit(name string) error {
+	if w.root != "/" && !strings.HasPrefix(name, w.root+"/") {
+		return nil
+	}
+	for _, dir := range w.skipped {
+		if strings.HasPrefix(name, dir+"/") {
+			return nil
+		}
+	}
+
+	// the parent directories from the root down
+	var dirs []string
+	for dir := path.Dir(name); !w.seen[dir]; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	for _, dir := range dirs {
+		w.seen[dir] = true
+		err := w.fn(Join(w.archive, w.archive.file, dir), w.archive.members[dir].FileInfo, nil)
+		if err == filepath.SkipDir {
+			w.skipped = append(w.skipped, dir)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err := w.fn(Join(w.archive, w.archive.file, name), w.archive.members[name].FileInfo, nil)
+	if err == filepath.SkipDir {
+		w.skipped = append(w.skipped, path.Dir(name))
+		return nil
+	}
+	return err
+}
+
+func (a *Archive) List(dir string) ([]os.FileInfo, error) {
+	name, err := a.member(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.list(); err != nil {
+		return nil, err
+	}
+	if name != "/" {
+		if m, ok := a.members[name]; !ok || !m.IsDir() {
+			return nil, &os.PathError{Op: "list", Path: dir, Err: os.ErrNotExist}
+		}
+	}
+
+	entries := append([]os.FileInfo(nil), a.entries[name]...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (a *Archive) Stat(p string) (os.FileInfo, error) {
+	name, err := a.member(p)
+	if err != nil {
+		return nil, err
+	}
+	if name == "/" {
+		return a.info, nil
+	}
+	m, ok := a.members[name]
+	if !ok && !a.listed {
+		if err := a.list(); err != nil {
+			return nil, err
+		}
+		m, ok = a.members[name]
+	}
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+	}
+	return m.FileInfo, nil
+}
+
+func (a *Archive) Open(p string) (io.ReadCloser, error) {
+	name, err := a.member(p)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := a.members[name]
+	if !ok && !a.listed {
+		if err := a.list(); err != nil {
+			return nil, err
+		}
+		m, ok = a.members[name]
+	}
+	if !ok || m.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	if m.zip != nil {
+		return m.zip.Open()
+	}
+
+	st, err := a.take(m.seq, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.seek(m.seq); err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	st.fresh = false
+	return &tarFile{Reader: st.tr, archive: a, stream: st}, nil
+}
+
+// tarFile reads a member from the stream, which is given back on close
+type tarFile struct {
+	io.Reader
+	archive *Archive
+	stream  *tarStream
+}
+
+func (f *tarFile) Close() error {
+	if f.stream != nil {
+		f.archive.release(f.stream)
+		f.stream = nil
+	}
+	return nil
+}
+
+func (a *Archive) Create(p string, _ time.Time) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "create", Path: p, Err: errReadOnly}
+}
+
+func (a *Archive) Rename(oldpath, _ string) error {
+	return &os.PathError{Op: "rename", Path: oldpath, Err: errReadOnly}
+}
+
+func (a *Archive) Remove(p string) error {
+	return &os.PathError{Op: "remove", Path: p, Err: errReadOnly}
+}
+
+// archiveDir is a directory in an archive, or the archive itself
+type archiveDir struct {
+	name    string
+	modTime time.Time
+}
+
+func (d archiveDir) Name() string       { return d.name }
+func (d archiveDir) Size() int64        { return 0 }
+func (d archiveDir) Mode() os.FileMode  { return os.ModeDir | 0500 }
+func (d archiveDir) ModTime() time.Time { return d.modTime }
+func (d archiveDir) IsDir() bool        { return true }
+func (d archiveDir) Sys() interface{}   { return nil }
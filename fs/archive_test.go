@@ -0,0 +1,142 @@
+package fs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// the members of the test tar in the archive, not sorted by name
+var testMembers = []struct {
+	name, content string
+}{
+	{"b/2.jpg", "second"},
+	{"a.jpg", "first"},
+	{"b/1.jpg", "third"},
+	{"a.jpg", "replaced"},
+}
+
+func writeTarGz(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	file := filepath.Join(dir, "test.tar.gz")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, m := range testMembers {
+		hdr := &tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.content)), ModTime: time.Unix(1500000000, 0), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(m.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gw, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file
+}
+
+func read(t *testing.T, s Storage, p string) string {
+	r, err := s.Open(p)
+	if err != nil {
+		t.Fatalf("Open(%s) = %v", p, err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read %s: %v", p, err)
+	}
+	return string(b)
+}
+
+func TestArchiveWalk(t *testing.T) {
+	file := writeTarGz(t)
+	a, err := NewArchive(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	// the first walk lists and reads the members in the archive, the replaced one twice
+	var walked []string
+	contents := make(map[string]string)
+	walk := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(file, p)
+		rel = filepath.ToSlash(rel)
+		walked = append(walked, rel)
+		if !info.IsDir() {
+			contents[rel] = read(t, a, p)
+		}
+		return nil
+	}
+	if err := Walk(a, file, walk); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "b", "b/2.jpg", "a.jpg", "b/1.jpg", "a.jpg"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("first walk = %v, want %v", walked, want)
+	}
+	wantContents := map[string]string{"a.jpg": "replaced", "b/1.jpg": "third", "b/2.jpg": "second"}
+	if !reflect.DeepEqual(contents, wantContents) {
+		t.Errorf("contents = %v, want %v", contents, wantContents)
+	}
+
+	// the second walk goes by the listing, a replaced member at its last place
+	walked = nil
+	contents = make(map[string]string)
+	if err := Walk(a, file, walk); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{".", "b", "b/2.jpg", "b/1.jpg", "a.jpg"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("second walk = %v, want %v", walked, want)
+	}
+	if !reflect.DeepEqual(contents, wantContents) {
+		t.Errorf("contents = %v, want %v", contents, wantContents)
+	}
+
+	// an earlier member is read again from the beginning
+	if got := read(t, a, filepath.Join(file, "b", "2.jpg")); got != "second" {
+		t.Errorf("b/2.jpg = %q after the last member", got)
+	}
+	entries, err := a.List(filepath.Join(file, "b"))
+	if err != nil || len(entries) != 2 || entries[0].Name() != "1.jpg" {
+		t.Errorf("List(b) = %v, %v", entries, err)
+	}
+}
+
+func TestArchiveOpenUnlisted(t *testing.T) {
+	file := writeTarGz(t)
+	a, err := NewArchive(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	// a member opened before any walk lists the archive first
+	if got := read(t, a, filepath.Join(file, "b", "1.jpg")); got != "third" {
+		t.Errorf("b/1.jpg = %q", got)
+	}
+	if _, err := a.Stat(filepath.Join(file, "c.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Stat(c.jpg) = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,75 @@
+package fs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Local is the local file system, its paths are of the OS.
+type Local struct {
+}
+
+var local = NewLocal()
+
+func NewLocal() *Local {
+	return &Local{}
+}
+
+// IsLocal reports whether the paths of s are of the local file system.
+func IsLocal(s Storage) bool {
+	_, ok := s.(*Local)
+	return ok
+}
+
+func (l *Local) List(dir string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dir)
+}
+
+func (l *Local) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (l *Local) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+func (l *Local) Create(path string, modTime time.Time) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0700)); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0644))
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{File: file, modTime: modTime}, nil
+}
+
+func (l *Local) Rename(oldpath, newpath string) error {
+	if err := os.MkdirAll(filepath.Dir(newpath), os.FileMode(0700)); err != nil {
+		return err
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+func (l *Local) Remove(path string) error {
+	return os.Remove(path)
+}
+
+type localFile struct {
+	*os.File
+	modTime time.Time
+}
+
+func (f *localFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	if f.modTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(f.Name(), time.Now(), f.modTime)
+}
@@ -0,0 +1,540 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the size of the parts of multipart uploads, the smaller files are put at once
+	s3PartSize = 16 << 20
+
+	s3MetaModTime = "X-Amz-Meta-Mtime"
+	s3TagSHA256   = "sha256"
+)
+
+// S3Config is the endpoint of the S3-compatible object storage, like AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the host and the optional port
+	Endpoint string
+	Region   string
+	// Insecure uses HTTP instead of HTTPS, for the local MinIO or the stand-in
+	Insecure bool
+	// AccessKey and SecretKey are AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY if empty
+	AccessKey string `mapstructure:"access-key"`
+	SecretKey string `mapstructure:"secret-key"`
+}
+
+var s3Config = S3Config{Endpoint: "s3.amazonaws.com", Region: "us-east-1"}
+
+// SetS3Config sets the endpoint of the s3:// locations.
+func SetS3Config(c S3Config) {
+	if c.Endpoint == "" {
+		c.Endpoint = s3Config.Endpoint
+	}
+	if c.Region == "" {
+		c.Region = s3Config.Region
+	}
+	s3Config = c
+}
+
+// S3 is a bucket of the object storage, the paths are the keys with a leading slash.
+// The SHA256 of the uploaded objects are kept in their tags, so they are compared without downloading.
+type S3 struct {
+	S3Config
+	Bucket string
+
+	client *http.Client
+	// maxKeys is the size of the pages of List, the one of the service if 0
+	maxKeys int
+}
+
+func NewS3(c S3Config, bucket string) *S3 {
+	if c.AccessKey == "" {
+		c.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if c.SecretKey == "" {
+		c.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return &S3{S3Config: c, Bucket: bucket, client: &http.Client{}}
+}
+
+func (s *S3) List(dir string) ([]os.FileInfo, error) {
+	prefix := s3Key(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var infos []os.FileInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	if s.maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(s.maxKeys))
+	}
+	for {
+		var result struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+				Size         int64
+			}
+			CommonPrefixes []struct {
+				Prefix string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := s.doXML(http.MethodGet, "", query, nil, nil, &result); err != nil {
+			return nil, &os.PathError{Op: "list", Path: dir, Err: err}
+		}
+
+		for _, p := range result.CommonPrefixes {
+			infos = append(infos, &objectInfo{name: path.Base(p.Prefix), dir: true})
+		}
+		for _, o := range result.Contents {
+			if o.Key == prefix {
+				continue
+			}
+			infos = append(infos, &objectInfo{name: path.Base(o.Key), size: o.Size, modTime: o.LastModified})
+		}
+
+		if !result.IsTruncated {
+			return infos, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *S3) Stat(name string) (os.FileInfo, error) {
+	key := s3Key(name)
+	if key == "" {
+		return &objectInfo{name: "/", dir: true}, nil
+	}
+
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		info := &objectInfo{name: path.Base(key), size: resp.ContentLength}
+		info.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+		if nsec, err := strconv.ParseInt(resp.Header.Get(s3MetaModTime), 10, 64); err == nil {
+			info.modTime = time.Unix(0, nsec)
+		}
+		return info, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	// the directories are the prefixes of the keys
+	var result struct {
+		KeyCount int
+	}
+	query := url.Values{"list-type": {"2"}, "prefix": {key + "/"}, "max-keys": {"1"}}
+	if err := s.doXML(http.MethodGet, "", query, nil, nil, &result); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if result.KeyCount <= 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return &objectInfo{name: path.Base(key), dir: true}, nil
+}
+
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s3Key(name), nil, nil, nil)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Create(name string, modTime time.Time) (io.WriteCloser, error) {
+	return &s3Writer{s3: s, name: name, modTime: modTime, sha256: sha256.New(), md5s: md5.New()}, nil
+}
+
+func (s *S3) Rename(oldpath, newpath string) error {
+	header := http.Header{"X-Amz-Copy-Source": {"/" + s.Bucket + "/" + s3Escape(s3Key(oldpath))}}
+	var result struct {
+		ETag string
+	}
+	if err := s.doXML(http.MethodPut, s3Key(newpath), nil, header, nil, &result); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return s.Remove(oldpath)
+}
+
+func (s *S3) Remove(name string) error {
+	resp, err := s.do(http.MethodDelete, s3Key(name), nil, nil, nil)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return resp.Body.Close()
+}
+
+// SHA256 returns the checksum in the tags of the object, or nil if it has none.
+func (s *S3) SHA256(name string) ([]byte, error) {
+	var tagging s3Tagging
+	if err := s.doXML(http.MethodGet, s3Key(name), url.Values{"tagging": {""}}, nil, nil, &tagging); err != nil {
+		return nil, err
+	}
+	for _, tag := range tagging.TagSet {
+		if tag.Key == s3TagSHA256 {
+			return hex.DecodeString(tag.Value)
+		}
+	}
+	return nil, nil
+}
+
+type s3Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []struct {
+		Key   string
+		Value string
+	} `xml:"TagSet>Tag"`
+}
+
+// s3Writer buffers a part, it puts the object at once if it is smaller than a part
+type s3Writer struct {
+	s3      *S3
+	name    string
+	modTime time.Time
+
+	buf      bytes.Buffer
+	uploadID string
+	parts    []s3Part
+	sha256   hash.Hash
+	// md5s are the checksums of the parts, for the ETag of the multipart upload
+	md5s hash.Hash
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	w.sha256.Write(p)
+	for w.buf.Len() >= s3PartSize {
+		if err := w.uploadPart(w.buf.Next(s3PartSize)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *s3Writer) header() http.Header {
+	header := http.Header{}
+	if !w.modTime.IsZero() {
+		header.Set(s3MetaModTime, strconv.FormatInt(w.modTime.UnixNano(), 10))
+	}
+	return header
+}
+
+func (w *s3Writer) uploadPart(data []byte) error {
+	key := s3Key(w.name)
+	if w.uploadID == "" {
+		var result struct {
+			UploadId string
+		}
+		if err := w.s3.doXML(http.MethodPost, key, url.Values{"uploads": {""}}, w.header(), nil, &result); err != nil {
+			return err
+		}
+		w.uploadID = result.UploadId
+	}
+
+	n := len(w.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {w.uploadID}}
+	sum := md5.Sum(data)
+	etag, err := w.s3.put(key, query, http.Header{}, data, sum[:])
+	if err != nil {
+		return err
+	}
+	w.md5s.Write(sum[:])
+	w.parts = append(w.parts, s3Part{PartNumber: n, ETag: etag})
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	if err := w.close(); err != nil {
+		if w.uploadID != "" {
+			if resp, err := w.s3.do(http.MethodDelete, s3Key(w.name), url.Values{"uploadId": {w.uploadID}}, nil, nil); err == nil {
+				resp.Body.Close()
+			}
+		}
+		return &os.PathError{Op: "write", Path: w.name, Err: err}
+	}
+	return nil
+}
+
+func (w *s3Writer) close() error {
+	key := s3Key(w.name)
+	checksum := hex.EncodeToString(w.sha256.Sum(nil))
+	if w.uploadID == "" {
+		header := w.header()
+		header.Set("X-Amz-Tagging", url.Values{s3TagSHA256: {checksum}}.Encode())
+		data := w.buf.Bytes()
+		sum := md5.Sum(data)
+		_, err := w.s3.put(key, nil, header, data, sum[:])
+		return err
+	}
+
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		ETag string
+	}
+	if err := w.s3.doXML(http.MethodPost, key, url.Values{"uploadId": {w.uploadID}}, nil, body, &result); err != nil {
+		return err
+	}
+	expected := fmt.Sprintf("%x-%d", w.md5s.Sum(nil), len(w.parts))
+	if etag := strings.Trim(result.ETag, `"`); etag != expected {
+		return fmt.Errorf("corrupted upload, ETag %s, expect %s", etag, expected)
+	}
+
+	var tagging s3Tagging
+	tagging.TagSet = append(tagging.TagSet, struct {
+		Key   string
+		Value string
+	}{Key: s3TagSHA256, Value: checksum})
+	body, err = xml.Marshal(tagging)
+	if err != nil {
+		return err
+	}
+	resp, err := w.s3.do(http.MethodPut, key, url.Values{"tagging": {""}}, nil, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// put uploads data with its MD5, and verifies the ETag returned
+func (s *S3) put(key string, query url.Values, header http.Header, data []byte, sum []byte) (string, error) {
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	resp, err := s.do(http.MethodPut, key, query, header, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	if strings.Trim(etag, `"`) != hex.EncodeToString(sum) {
+		return "", fmt.Errorf("corrupted upload, ETag %s, expect %x", etag, sum)
+	}
+	return etag, nil
+}
+
+// doXML sends the request and decodes the XML response into v
+func (s *S3) doXML(method string, key string, query url.Values, header http.Header, body []byte, v interface{}) error {
+	resp, err := s.do(method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// some errors come with 200 OK
+	head := data
+	if len(head) > 128 {
+		head = head[:128]
+	}
+	if bytes.Contains(head, []byte("<Error>")) {
+		return s3Error(resp.StatusCode, data)
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// do sends the request signed by AWS Signature Version 4, the errors of missing keys satisfy os.IsNotExist
+func (s *S3) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	scheme := "https"
+	if s.Insecure {
+		scheme = "http"
+	}
+	uri := "/" + s.Bucket
+	if key != "" {
+		uri += "/" + s3Escape(key)
+	}
+	rawQuery := s3Query(query)
+
+	req, err := http.NewRequest(method, scheme+"://"+s.Endpoint+uri+"?"+rawQuery, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, uri, rawQuery, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return nil, s3Error(resp.StatusCode, data)
+}
+
+func s3Error(status int, data []byte) error {
+	var e struct {
+		Code    string
+		Message string
+	}
+	_ = xml.Unmarshal(data, &e)
+	if status == http.StatusNotFound || e.Code == "NoSuchKey" {
+		return os.ErrNotExist
+	}
+	if e.Code == "" {
+		return fmt.Errorf("s3: %s", http.StatusText(status))
+	}
+	return fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+}
+
+// sign signs req at now, uri and rawQuery are canonical
+func (s *S3) sign(req *http.Request, uri string, rawQuery string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if s.AccessKey == "" {
+		return
+	}
+
+	var names []string
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" {
+			headers[name] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n" + uri + "\n" + rawQuery + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n" + hex.EncodeToString(payload[:]))
+
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	k := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format("20060102"))
+	k = hmacSHA256(k, s.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+// s3Key is the object key of the path
+func s3Key(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// s3Escape encodes the key as the URI of Signature Version 4, keeping the slashes
+func s3Escape(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = s3Encode(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+func s3Encode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query is the canonical query of Signature Version 4
+func s3Query(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, s3Encode(k)+"="+s3Encode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (o *objectInfo) Name() string       { return o.name }
+func (o *objectInfo) Size() int64        { return o.size }
+func (o *objectInfo) ModTime() time.Time { return o.modTime }
+func (o *objectInfo) IsDir() bool        { return o.dir }
+func (o *objectInfo) Sys() interface{}   { return nil }
+
+func (o *objectInfo) Mode() os.FileMode {
+	if o.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,324 @@
+package fs
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+)
+
+// SFTPConfig is how to connect the sftp:// locations.
+type SFTPConfig struct {
+	// SSH is the ssh command, so the config, the keys and the agent of the user are used
+	SSH string
+}
+
+var sftpConfig = SFTPConfig{SSH: "ssh"}
+
+// SetSFTPConfig sets how to connect the sftp:// locations.
+func SetSFTPConfig(c SFTPConfig) {
+	if c.SSH == "" {
+		c.SSH = sftpConfig.SSH
+	}
+	sftpConfig = c
+}
+
+// SFTP is a host over the sftp subsystem of ssh, the paths are of the host.
+// The SHA256 of its files are computed by sha256sum on the host if it has, so they are compared without downloading.
+type SFTP struct {
+	SFTPConfig
+	User string
+	Host string
+	Port string
+
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	client *sftp.Client
+	err    error
+
+	// the checksums are computed by one shell on the host for all the files
+	sumMutex sync.Mutex
+	sumCmd   *exec.Cmd
+	sumIn    io.WriteCloser
+	sumOut   *bufio.Reader
+	// noSum is set if the host has no sha256sum
+	noSum bool
+}
+
+func NewSFTP(c SFTPConfig, user string, host string, port string) *SFTP {
+	return &SFTP{SFTPConfig: c, User: user, Host: host, Port: port}
+}
+
+// sshArgs are the arguments of ssh to the host
+func (s *SFTP) sshArgs() []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if s.Port != "" {
+		args = append(args, "-p", s.Port)
+	}
+	if s.User != "" {
+		args = append(args, "-l", s.User)
+	}
+	return append(args, s.Host)
+}
+
+// connect starts the sftp subsystem once, and returns its client
+func (s *SFTP) connect() (*sftp.Client, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.client != nil || s.err != nil {
+		return s.client, s.err
+	}
+
+	cmd := exec.Command(s.SSH, append(s.sshArgs(), "-s", "sftp")...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		s.err = err
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		s.err = err
+		return nil, err
+	}
+	zap.L().Debug(cmd.String())
+	if err := cmd.Start(); err != nil {
+		s.err = err
+		return nil, err
+	}
+
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		stdin.Close()
+		_ = cmd.Wait()
+		s.err = fmt.Errorf("sftp: %s: %w", s.Host, err)
+		return nil, s.err
+	}
+	s.cmd, s.stdin, s.client = cmd, stdin, client
+	return client, nil
+}
+
+// Close ends the sftp subsystem.
+func (s *SFTP) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sumMutex.Lock()
+	s.stopSum()
+	s.sumMutex.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client, s.err = nil, errors.New("sftp: closed")
+	if s.cmd != nil {
+		s.stdin.Close()
+		if e := s.cmd.Wait(); err == nil {
+			err = e
+		}
+		s.cmd, s.stdin = nil, nil
+	}
+	return err
+}
+
+// List returns the entries of dir, a symbolic link is of its own mode.
+func (s *SFTP) List(dir string) ([]os.FileInfo, error) {
+	c, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return c.ReadDir(dir)
+}
+
+// Stat returns the file name is, or a symbolic link points to.
+func (s *SFTP) Stat(name string) (os.FileInfo, error) {
+	c, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return c.Stat(name)
+}
+
+// Open reads name by the concurrent requests of sftp when it is copied.
+func (s *SFTP) Open(name string) (io.ReadCloser, error) {
+	c, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return c.Open(name)
+}
+
+func (s *SFTP) Create(name string, modTime time.Time) (io.WriteCloser, error) {
+	c, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mkdirAll(c, path.Dir(name)); err != nil {
+		return nil, err
+	}
+	f, err := c.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &sftpWriter{File: f, client: c, modTime: modTime}, nil
+}
+
+func (s *SFTP) Rename(oldpath, newpath string) error {
+	c, err := s.connect()
+	if err != nil {
+		return err
+	}
+	if err := s.mkdirAll(c, path.Dir(newpath)); err != nil {
+		return err
+	}
+	return c.Rename(oldpath, newpath)
+}
+
+func (s *SFTP) Remove(name string) error {
+	c, err := s.connect()
+	if err != nil {
+		return err
+	}
+	return c.Remove(name)
+}
+
+func (s *SFTP) mkdirAll(c *sftp.Client, dir string) error {
+	if info, err := c.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if parent := path.Dir(dir); parent != dir {
+		if err := s.mkdirAll(c, parent); err != nil {
+			return err
+		}
+	}
+	if err := c.Mkdir(dir); err != nil {
+		return err
+	}
+	return c.Chmod(dir, 0700)
+}
+
+// SHA256 computes the checksum on the host by sha256sum, or returns nil if it cannot.
+func (s *SFTP) SHA256(name string) ([]byte, error) {
+	s.sumMutex.Lock()
+	defer s.sumMutex.Unlock()
+	if s.noSum {
+		return nil, nil
+	}
+	if err := s.startSum(); err != nil {
+		return nil, err
+	}
+
+	// the exit status follows the output, 127 is of the shell for a missing command
+	if _, err := fmt.Fprintf(s.sumIn, "sha256sum -- %s; echo \"! $?\"\n", shellQuote(name)); err != nil {
+		s.stopSum()
+		return nil, err
+	}
+	var out, status string
+	for status == "" {
+		line, err := s.sumOut.ReadString('\n')
+		if err != nil {
+			s.stopSum()
+			return nil, err
+		}
+		if strings.HasPrefix(line, "! ") {
+			status = strings.TrimSpace(line[2:])
+		} else {
+			out = line
+		}
+	}
+
+	switch status {
+	case "0":
+	case "127":
+		zap.L().Info("no sha256sum on the host", zap.String("host", s.Host))
+		s.noSum = true
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("sha256sum of %s exited with %s", name, status)
+	}
+
+	// the sum of an escaped name starts with a backslash
+	fields := strings.Fields(strings.TrimPrefix(out, "\\"))
+	if len(fields) <= 0 {
+		return nil, fmt.Errorf("invalid sha256sum of %s: %q", name, out)
+	}
+	return hex.DecodeString(fields[0])
+}
+
+// startSum starts the shell of the checksums once, again after it failed
+func (s *SFTP) startSum() error {
+	if s.sumCmd != nil {
+		return nil
+	}
+
+	cmd := exec.Command(s.SSH, append(s.sshArgs(), "sh")...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	zap.L().Debug(cmd.String())
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.sumCmd, s.sumIn, s.sumOut = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+func (s *SFTP) stopSum() {
+	if s.sumCmd == nil {
+		return
+	}
+	s.sumIn.Close()
+	_ = s.sumCmd.Wait()
+	s.sumCmd, s.sumIn, s.sumOut = nil, nil, nil
+}
+
+// shellQuote quotes s for the shell of the host
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sftpWriter sets the modification time of the file on close
+type sftpWriter struct {
+	*sftp.File
+	client  *sftp.Client
+	modTime time.Time
+}
+
+func (w *sftpWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+	if w.modTime.IsZero() {
+		return nil
+	}
+	return w.client.Chtimes(w.File.Name(), w.modTime, w.modTime)
+}
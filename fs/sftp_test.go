@@ -0,0 +1,156 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// pipeConn is one end of the connection between the test client and server
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// newTestSFTP serves a temporary directory by sftp in the test,
+// and runs the shell of the checksums by a fake ssh
+func newTestSFTP(t *testing.T) (*SFTP, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the paths of sftp are of a Unix host")
+	}
+	dir, err := ioutil.TempDir("", "sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ssh := filepath.Join(dir, "ssh")
+	if err := ioutil.WriteFile(ssh, []byte("#!/bin/sh\nexec sh\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(pipeConn{sr, sw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		// the server ends on the close of the client, and closes its side for the client to end
+		_ = server.Serve()
+		server.Close()
+	}()
+	client, err := sftp.NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSFTP(SFTPConfig{SSH: ssh}, "", "localhost", "")
+	s.client = client
+	t.Cleanup(func() { s.Close() })
+	return s, root
+}
+
+func TestSFTP(t *testing.T) {
+	s, root := newTestSFTP(t)
+	modTime := time.Date(2015, 8, 1, 10, 20, 30, 0, time.UTC)
+	files := map[string]string{
+		"photos/a b.jpg":     "spaces",
+		"photos/照片.jpg":      "non-ASCII",
+		"photos/it's.jpg":    "quote",
+		"photos/sub/top.jpg": "deep",
+	}
+	for p, content := range files {
+		put(t, s, root+"/"+p, []byte(content), modTime)
+	}
+
+	for p, content := range files {
+		info, err := s.Stat(root + "/" + p)
+		if err != nil {
+			t.Fatalf("Stat(%s) = %v", p, err)
+		}
+		if info.IsDir() || info.Size() != int64(len(content)) || !info.ModTime().Equal(modTime) || info.Mode().Perm() != 0644 {
+			t.Errorf("Stat(%s) = dir %v, size %d, time %v, mode %v", p, info.IsDir(), info.Size(), info.ModTime(), info.Mode())
+		}
+		if got := read(t, s, root+"/"+p); got != content {
+			t.Errorf("Open(%s) = %q, want %q", p, got, content)
+		}
+		sum, err := s.SHA256(root + "/" + p)
+		if want := sha256.Sum256([]byte(content)); err != nil || !bytes.Equal(sum, want[:]) {
+			t.Errorf("SHA256(%s) = %x, %v", p, sum, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(root, "photos", "sub")); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("made directory = %v, %v", info, err)
+	}
+
+	// a symbolic link is listed as one, and stated as the file it points to
+	if err := os.Symlink("a b.jpg", filepath.Join(root, "photos", "link.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := s.List(root + "/photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a b.jpg", "it's.jpg", "link.jpg", "sub/", "照片.jpg"}
+	if got := names(infos); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+	for _, info := range infos {
+		if info.Name() == "link.jpg" && info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("List() mode of link.jpg = %v, want symbolic link", info.Mode())
+		}
+	}
+	if info, err := s.Stat(root + "/photos/link.jpg"); err != nil || !info.Mode().IsRegular() || info.Size() != int64(len("spaces")) {
+		t.Errorf("Stat(link.jpg) = %v, %v, want the file", info, err)
+	}
+
+	if err := s.Rename(root+"/photos/a b.jpg", root+"/renamed/new/a b.jpg"); err != nil {
+		t.Fatalf("Rename() = %v", err)
+	}
+	if _, err := s.Stat(root + "/photos/a b.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Stat of renamed = %v, want not exist", err)
+	}
+	if got := read(t, s, root+"/renamed/new/a b.jpg"); got != "spaces" {
+		t.Errorf("renamed = %q", got)
+	}
+
+	if err := s.Remove(root + "/photos/照片.jpg"); err != nil {
+		t.Fatalf("Remove() = %v", err)
+	}
+	if _, err := s.Open(root + "/photos/照片.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Open of removed = %v, want not exist", err)
+	}
+	if _, err := s.SHA256(root + "/missing"); err == nil {
+		t.Errorf("SHA256(missing) = nil error")
+	}
+}
+
+func TestSFTPLarge(t *testing.T) {
+	s, root := newTestSFTP(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16+7)
+	put(t, s, root+"/big/video.mp4", data, time.Time{})
+
+	r, err := s.Open(root + "/big/video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Open() = %d bytes, %v, want %d bytes", buf.Len(), err, len(data))
+	}
+}
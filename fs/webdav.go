@@ -0,0 +1,91 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// WebDAVConfig is how to connect the webdav:// locations.
+type WebDAVConfig struct {
+	// Insecure uses HTTP instead of HTTPS
+	Insecure bool
+	// Password is WEBDAV_PASSWORD if it is empty and not in the URL
+	Password string
+}
+
+var webdavConfig WebDAVConfig
+
+// SetWebDAVConfig sets how to connect the webdav:// locations.
+func SetWebDAVConfig(c WebDAVConfig) {
+	webdavConfig = c
+}
+
+// WebDAV is a server like Nextcloud, the paths are of the server.
+// The modification times are not kept, they are of the uploads.
+type WebDAV struct {
+	client *gowebdav.Client
+}
+
+// NewWebDAV connects root, the URL of the server.
+func NewWebDAV(root string, user string, password string) *WebDAV {
+	return &WebDAV{client: gowebdav.NewClient(root, user, password)}
+}
+
+// webdavError makes the errors of missing files satisfy os.IsNotExist
+func webdavError(op string, name string, err error) error {
+	if gowebdav.IsErrNotFound(err) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+func (w *WebDAV) List(dir string) ([]os.FileInfo, error) {
+	infos, err := w.client.ReadDir(dir)
+	return infos, webdavError("list", dir, err)
+}
+
+func (w *WebDAV) Stat(name string) (os.FileInfo, error) {
+	info, err := w.client.Stat(name)
+	return info, webdavError("stat", name, err)
+}
+
+func (w *WebDAV) Open(name string) (io.ReadCloser, error) {
+	stream, err := w.client.ReadStream(name)
+	return stream, webdavError("open", name, err)
+}
+
+// Create streams the content to the server while it is written.
+func (w *WebDAV) Create(name string, modTime time.Time) (io.WriteCloser, error) {
+	r, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := w.client.WriteStream(name, r, 0644)
+		// unblock the writer if the upload failed
+		r.CloseWithError(err)
+		done <- err
+	}()
+	return &webdavFile{PipeWriter: pw, done: done}, nil
+}
+
+func (w *WebDAV) Rename(oldpath, newpath string) error {
+	return webdavError("rename", oldpath, w.client.Rename(oldpath, newpath, false))
+}
+
+func (w *WebDAV) Remove(name string) error {
+	return webdavError("remove", name, w.client.Remove(name))
+}
+
+type webdavFile struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (f *webdavFile) Close() error {
+	if err := f.PipeWriter.Close(); err != nil {
+		return err
+	}
+	return <-f.done
+}
@@ -0,0 +1,318 @@
+package geo
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	earthRadius = 6371.0 // km
+	// lookups are cached by the coordinates rounded to about 1km
+	cachePrecision = 100
+	// maxDistance is how far from the nearest city a place is still named by it, in km,
+	// wide enough for the sparse bundled cities and not to name the sea or the wild
+	maxDistance = 200.0
+	// maxDegrees is maxDistance in the degrees of latitude
+	maxDegrees = maxDistance / earthRadius * 180 / math.Pi
+)
+
+var (
+	//go:embed cities.tsv
+	bundledCities []byte
+	//go:embed countries.tsv
+	bundledCountries []byte
+
+	dmsPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?) deg (\d+(?:\.\d+)?)' (\d+(?:\.\d+)?)"\s*([NSEW])?$`)
+
+	citiesFile string
+	once       sync.Once
+	geocoder   *Geocoder
+)
+
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Distance returns the great-circle distance in km.
+func (c Coordinates) Distance(other Coordinates) float64 {
+	lat1, lat2 := radians(c.Latitude), radians(other.Latitude)
+	dLat, dLon := lat2-lat1, radians(other.Longitude-c.Longitude)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+type City struct {
+	Name        string
+	Region      string
+	CountryCode string
+	Coordinates
+}
+
+// Place is where a medium was shot, by the nearest city.
+type Place struct {
+	Country     string
+	CountryCode string
+	Region      string
+	City        string
+	// Distance is from the city in km
+	Distance float64
+}
+
+// Geocoder finds the nearest city of coordinates.
+type Geocoder struct {
+	cities    []City
+	countries map[string]string
+	// grid is the indexes of the cities by the cells of one degree
+	grid map[[2]int][]int
+
+	mutex sync.Mutex
+	cache map[[2]int64]Place
+}
+
+func NewGeocoder(cities []City, countries map[string]string) *Geocoder {
+	grid := make(map[[2]int][]int)
+	for i := range cities {
+		key := cell(cities[i].Latitude, cities[i].Longitude)
+		grid[key] = append(grid[key], i)
+	}
+	return &Geocoder{
+		cities:    cities,
+		countries: countries,
+		grid:      grid,
+		cache:     make(map[[2]int64]Place),
+	}
+}
+
+// cell returns the grid cell of the coordinates, the longitude wrapped into [-180, 180)
+func cell(lat float64, lon float64) [2]int {
+	lon = math.Mod(math.Mod(lon+180, 360)+360, 360) - 180
+	return [2]int{int(math.Floor(lat)), int(math.Floor(lon))}
+}
+
+// nearest returns the nearest city in the cells within maxDistance, -1 if none
+func (g *Geocoder) nearest(c Coordinates) (int, float64) {
+	// the degrees of longitude within maxDistance widen to the poles
+	lonDegrees := 180.0
+	if far := math.Abs(c.Latitude) + maxDegrees; far < 89 {
+		lonDegrees = math.Min(lonDegrees, maxDegrees/math.Cos(radians(far)))
+	}
+	lons := int(math.Ceil(lonDegrees))
+
+	nearest, distance := -1, math.MaxFloat64
+	visited := make(map[[2]int]bool)
+	for lat := int(math.Floor(c.Latitude - maxDegrees)); lat <= int(math.Floor(c.Latitude+maxDegrees)); lat++ {
+		for lon := -lons; lon <= lons; lon++ {
+			key := cell(float64(lat), c.Longitude+float64(lon))
+			if visited[key] {
+				continue
+			}
+			visited[key] = true
+			for _, i := range g.grid[key] {
+				if d := c.Distance(g.cities[i].Coordinates); d < distance {
+					nearest, distance = i, d
+				}
+			}
+		}
+	}
+	return nearest, distance
+}
+
+// Lookup returns the place of the nearest city, false if no city is within maxDistance.
+func (g *Geocoder) Lookup(c Coordinates) (Place, bool) {
+	if len(g.cities) <= 0 {
+		return Place{}, false
+	}
+
+	key := [2]int64{int64(math.Round(c.Latitude * cachePrecision)), int64(math.Round(c.Longitude * cachePrecision))}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	// the places too far from any city are cached empty
+	if place, ok := g.cache[key]; ok {
+		return place, place.City != ""
+	}
+
+	nearest, distance := g.nearest(c)
+	if nearest < 0 || distance > maxDistance {
+		g.cache[key] = Place{}
+		return Place{}, false
+	}
+
+	city := &g.cities[nearest]
+	place := Place{
+		Country:     g.countries[city.CountryCode],
+		CountryCode: city.CountryCode,
+		Region:      city.Region,
+		City:        city.Name,
+		Distance:    distance,
+	}
+	if place.Country == "" {
+		place.Country = city.CountryCode
+	}
+	g.cache[key] = place
+	return place, true
+}
+
+// SetCitiesFile uses the GeoNames cities file, like cities15000.txt, instead of the bundled cities.
+// The region names are read from admin1CodesASCII.txt in the same directory if it exists.
+func SetCitiesFile(path string) {
+	citiesFile = path
+}
+
+// Lookup returns the place of the nearest city by the default geocoder.
+func Lookup(c Coordinates) (Place, bool) {
+	once.Do(loadDefault)
+	return geocoder.Lookup(c)
+}
+
+func loadDefault() {
+	countries, err := readCountries(bytes.NewReader(bundledCountries))
+	if err != nil {
+		zap.L().Error("invalid bundled countries", zap.Error(err))
+	}
+
+	if citiesFile != "" {
+		cities, err := LoadGeoNames(citiesFile, filepath.Join(filepath.Dir(citiesFile), "admin1CodesASCII.txt"))
+		if err == nil {
+			geocoder = NewGeocoder(cities, countries)
+			return
+		}
+		zap.L().Info("use bundled cities", zap.String("cities", citiesFile), zap.Error(err))
+	}
+
+	cities, err := readCities(bytes.NewReader(bundledCities))
+	if err != nil {
+		zap.L().Error("invalid bundled cities", zap.Error(err))
+	}
+	geocoder = NewGeocoder(cities, countries)
+}
+
+// readCities reads the bundled cities: name, region, country code, latitude and longitude
+func readCities(r io.Reader) ([]City, error) {
+	var cities []City
+	err := readTSV(r, 5, func(fields []string) error {
+		lat, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return err
+		}
+		lon, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return err
+		}
+		cities = append(cities, City{Name: fields[0], Region: fields[1], CountryCode: fields[2],
+			Coordinates: Coordinates{Latitude: lat, Longitude: lon}})
+		return nil
+	})
+	return cities, err
+}
+
+// readCountries reads the bundled countries: code and name
+func readCountries(r io.Reader) (map[string]string, error) {
+	countries := make(map[string]string)
+	err := readTSV(r, 2, func(fields []string) error {
+		countries[fields[0]] = fields[1]
+		return nil
+	})
+	return countries, err
+}
+
+// LoadGeoNames reads the cities file of GeoNames, and the region names from the admin1 codes file if it exists.
+func LoadGeoNames(citiesPath string, admin1Path string) ([]City, error) {
+	regions := make(map[string]string)
+	if file, err := os.Open(admin1Path); err == nil {
+		err = readTSV(file, 2, func(fields []string) error {
+			regions[fields[0]] = fields[1]
+			return nil
+		})
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.Open(citiesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cities []City
+	// geonameid, name, asciiname, alternatenames, latitude, longitude, feature class, feature code,
+	// country code, cc2, admin1 code, ...
+	err = readTSV(file, 11, func(fields []string) error {
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return err
+		}
+		lon, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return err
+		}
+		cc := fields[8]
+		cities = append(cities, City{Name: fields[1], Region: regions[cc+"."+fields[10]], CountryCode: cc,
+			Coordinates: Coordinates{Latitude: lat, Longitude: lon}})
+		return nil
+	})
+	return cities, err
+}
+
+func readTSV(r io.Reader, columns int, fn func(fields []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) < columns {
+			return fmt.Errorf("line %d: expect %d columns, got %d", line, columns, len(fields))
+		}
+		if err := fn(fields); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// ParseCoordinate parses the GPS coordinate of exiftool, like 35 deg 39' 29.16" N or -35.6581.
+func ParseCoordinate(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f, true
+	}
+
+	m := dmsPattern.FindStringSubmatch(value)
+	if m == nil {
+		return 0, false
+	}
+	deg, _ := strconv.ParseFloat(m[1], 64)
+	min, _ := strconv.ParseFloat(m[2], 64)
+	sec, _ := strconv.ParseFloat(m[3], 64)
+	f := deg + min/60 + sec/3600
+	if m[4] == "S" || m[4] == "W" {
+		f = -f
+	}
+	return f, true
+}
@@ -0,0 +1,44 @@
+package geo
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestLookupGrid(t *testing.T) {
+	cities, err := readCities(bytes.NewReader(bundledCities))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cities across the antimeridian and near the poles
+	cities = append(cities,
+		City{Name: "East", CountryCode: "FJ", Coordinates: Coordinates{Latitude: -17, Longitude: 179.9}},
+		City{Name: "North", CountryCode: "NO", Coordinates: Coordinates{Latitude: 89.5, Longitude: -170}},
+	)
+	g := NewGeocoder(cities, nil)
+
+	points := []Coordinates{{-17, -179.9}, {89.9, 10}, {88.5, 175}, {-90, 0}}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		points = append(points, Coordinates{Latitude: r.Float64()*180 - 90, Longitude: r.Float64()*360 - 180})
+	}
+	for _, c := range points {
+		// the nearest by scanning all the cities
+		want, distance := "", math.MaxFloat64
+		for _, city := range cities {
+			if d := c.Distance(city.Coordinates); d < distance {
+				want, distance = city.Name, d
+			}
+		}
+		if distance > maxDistance {
+			want = ""
+		}
+
+		place, ok := g.Lookup(c)
+		if place.City != want || ok != (want != "") {
+			t.Errorf("Lookup(%v) = %s, %v, want %s", c, place.City, ok, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	rangeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+	clockCorrections []*ClockCorrection
+)
+
+// ClockCorrection fixes the shooting times of a camera whose clock was wrong.
+type ClockCorrection struct {
+	// Model is the camera model in EXIF, the correction matches any model if it is empty
+	Model string
+	// Serial is the optional serial number of the camera
+	Serial string
+	// From and To are the optional range of the camera times to fix, as 2006-01-02 or RFC 3339
+	From string
+	To   string
+	// Offset is added to the camera times
+	Offset time.Duration
+
+	from time.Time
+	to   time.Time
+}
+
+// SetClockCorrections replaces the corrections applied by ShootingTime.
+func SetClockCorrections(corrections []*ClockCorrection) error {
+	for _, c := range corrections {
+		if err := c.Init(); err != nil {
+			return err
+		}
+	}
+	clockCorrections = corrections
+	return nil
+}
+
+// Init parses the range of the correction.
+func (c *ClockCorrection) Init() error {
+	var err error
+	if c.from, err = parseRangeTime(c.From); err != nil {
+		return err
+	}
+	if c.to, err = parseRangeTime(c.To); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseRangeTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range rangeLayouts {
+		if t, err := time.ParseInLocation(layout, value, DefaultLocation); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q in clock correction", value)
+}
+
+// Match reports whether the camera time t of meta should be fixed.
+func (c *ClockCorrection) Match(meta *Meta, t time.Time) bool {
+	if meta == nil {
+		return false
+	}
+
+	if c.Model != "" && !strings.EqualFold(strings.TrimSpace(meta.Model), c.Model) {
+		return false
+	}
+
+	if c.Serial != "" && c.Serial != meta.Serial() {
+		return false
+	}
+
+	if !c.from.IsZero() && t.Before(c.from) {
+		return false
+	}
+	if !c.to.IsZero() && !t.Before(c.to) {
+		return false
+	}
+	return true
+}
+
+// correctClock applies the first matched correction to the camera time
+func correctClock(meta *Meta, shooting ShootingTime) ShootingTime {
+	if !shooting.Valid() {
+		return shooting
+	}
+
+	switch shooting.Source {
+	case SourceFileCreateDate, SourceFileModifyDate:
+		// not by the camera clock
+		return shooting
+	}
+
+	for _, c := range clockCorrections {
+		if c.Match(meta, shooting.Time) {
+			shooting.Time = shooting.Time.Add(c.Offset)
+			shooting.Correction = c.Offset
+			return shooting
+		}
+	}
+	return shooting
+}
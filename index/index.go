@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
 	"os/exec"
@@ -8,24 +9,67 @@ import (
 	"strings"
 
 	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/progress"
 )
 
+// MetaDir is the directory name where bkpic keeps its own data, it is never indexed.
+const MetaDir = ".bkpic"
+
 type Index struct {
 	mediaBySize map[int64]Media
 	media       map[string]*Medium
 	dir         string
 	ignored     map[string]bool
+	storage     fs.Storage
+	// skip leaves files out of the walk by their paths
+	skip func(path string) bool
 }
 
 func NewEmptyIndex() *Index {
+	return NewStorageIndex(fs.NewLocal())
+}
+
+// NewStorageIndex creates an empty index of the files in s.
+func NewStorageIndex(s fs.Storage) *Index {
 	return &Index{
 		mediaBySize: make(map[int64]Media),
 		media:       make(map[string]*Medium),
+		storage:     s,
+	}
+}
+
+// NewIndex indexes the location of fs.Parse.
+func NewIndex(location string) (*Index, error) {
+	return NewIndexExcept(location, nil)
+}
+
+// NewIndexExcept indexes the location of fs.Parse without the files skip reports, like the ones handled before.
+func NewIndexExcept(location string, skip func(path string) bool) (*Index, error) {
+	s, dir, err := fs.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if fs.IsLocal(s) {
+		if dir, err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	idx := NewStorageIndex(s)
+	idx.skip = skip
+	if err := idx.Walk(dir, nil); err != nil {
+		fs.Close(s)
+		return nil, err
 	}
+	idx.dir = dir
+	return idx, nil
 }
 
-func NewIndex(dir string) (*Index, error) {
-	idx := NewEmptyIndex()
+// NewIndexIn indexes dir of s.
+func NewIndexIn(s fs.Storage, dir string) (*Index, error) {
+	idx := NewStorageIndex(s)
 	if err := idx.Walk(dir, nil); err != nil {
 		return nil, err
 	}
@@ -33,15 +77,27 @@ func NewIndex(dir string) (*Index, error) {
 	return idx, nil
 }
 
+// NewFilesIndex indexes some files of dir in s, the others of dir are left out.
+func NewFilesIndex(s fs.Storage, dir string, files []string) *Index {
+	idx := NewStorageIndex(s)
+	for _, fullPath := range files {
+		idx.Add(fullPath)
+	}
+	idx.dir = dir
+	return idx
+}
+
 func (idx *Index) Walk(dir string, ignored map[string]bool) error {
-	var err error
-	dir, err = filepath.Abs(dir)
-	if err != nil {
-		return err
+	if fs.IsLocal(idx.storage) {
+		var err error
+		dir, err = filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
 	}
 
 	idx.ignored = ignored
-	if err := filepath.Walk(dir, idx.walk); err != nil {
+	if err := fs.Walk(idx.storage, dir, idx.walk); err != nil {
 		return err
 	}
 	return nil
@@ -55,7 +111,32 @@ func (idx *Index) walk(path string, info os.FileInfo, err error) error {
 		return nil
 	}
 
-	if info.IsDir() || info.Size() <= 0 {
+	if info.IsDir() {
+		if info.Name() == MetaDir {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	if idx.skip != nil && idx.skip(path) {
+		return nil
+	}
+
+	// the walk lists by lstat, the medium is of the file a link points to
+	if info.Mode()&os.ModeSymlink != 0 {
+		info, err = idx.storage.Stat(path)
+		if err != nil {
+			zap.L().Debug("invalid link",
+				zap.Error(err),
+				zap.String("path", path))
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+	}
+
+	if info.Size() <= 0 {
 		return nil
 	}
 
@@ -66,17 +147,27 @@ func (idx *Index) walk(path string, info os.FileInfo, err error) error {
 		}
 	}
 
-	idx.Add(path)
+	// a later member of the same name in a tar replaces the earlier one
+	idx.Remove(path)
+	m := newStorageMedium(idx.storage, path, info)
+	if fs.IsSequential(idx.storage) {
+		m.readOnce()
+	}
+	idx.add(m)
+	progress.Add(progress.Indexed, 1, info.Size())
 	return nil
 }
 
 func (idx *Index) Add(fullPath string) {
-	medium := NewMedium(fullPath)
+	medium := NewStorageMedium(idx.storage, fullPath)
 	if medium == nil {
 		//zap.L().Error("invalid medium", zap.String("file", fullPath))
 		return
 	}
+	idx.add(medium)
+}
 
+func (idx *Index) add(medium *Medium) {
 	info := medium.FileInfo
 
 	hashes, ok := idx.mediaBySize[info.Size()]
@@ -89,10 +180,38 @@ func (idx *Index) Add(fullPath string) {
 	idx.media[medium.FullPath] = medium
 }
 
+// Remove drops the medium of fullPath from the index.
+func (idx *Index) Remove(fullPath string) {
+	medium, ok := idx.media[fullPath]
+	if !ok {
+		return
+	}
+	delete(idx.media, fullPath)
+
+	size := medium.FileInfo.Size()
+	media := idx.mediaBySize[size]
+	for i, m := range media {
+		if m == medium {
+			media = append(media[:i], media[i+1:]...)
+			break
+		}
+	}
+	if len(media) > 0 {
+		idx.mediaBySize[size] = media
+	} else {
+		delete(idx.mediaBySize, size)
+	}
+}
+
 func (idx *Index) Directory() string {
 	return idx.dir
 }
 
+// Storage returns where the media of the index are kept.
+func (idx *Index) Storage() fs.Storage {
+	return idx.storage
+}
+
 func (idx *Index) Get(fullPath string) *Medium {
 	return idx.media[fullPath]
 }
@@ -115,9 +234,26 @@ func (idx *Index) Size() int {
 }
 
 func (idx *Index) LoadMeta() error {
+	if len(idx.media) <= 0 {
+		zap.L().Info("no media", zap.String("directory", idx.dir))
+		return nil
+	}
+
+	// exiftool reads the others one by one by Medium.Meta
+	if !fs.IsLocal(idx.storage) {
+		return nil
+	}
+
+	// pass the indexed files by argfile, so removed ones are not scanned again
+	files := new(bytes.Buffer)
+	for fullPath := range idx.media {
+		files.WriteString(fullPath)
+		files.WriteByte('\n')
+	}
 
-	args := append(exiftoolFlags, idx.dir)
+	args := append(exiftoolFlags, "-@", "-")
 	cmd := exec.Command("exiftool", args...)
+	cmd.Stdin = files
 	zap.L().Debug(cmd.String())
 
 	stdout, err := cmd.StdoutPipe()
@@ -150,6 +286,7 @@ func (idx *Index) LoadMeta() error {
 			continue
 		}
 		medium.meta = m
+		medium.metaDone = true
 	}
 	return nil
 }
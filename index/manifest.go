@@ -0,0 +1,205 @@
+package index
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/enjoypi/bkpic/fs"
+)
+
+const manifestFile = "manifest.json"
+
+// ManifestEntry is the state of a file when it was written or verified.
+type ManifestEntry struct {
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Verified is the last time the file was found intact
+	Verified *time.Time `json:"verified,omitempty"`
+}
+
+// Manifest keeps the checksums of the files in a directory, by their slash-separated relative paths,
+// so that the damaged ones are found later.
+type Manifest struct {
+	Files map[string]*ManifestEntry `json:"files"`
+
+	storage fs.Storage
+	path    string
+}
+
+// LoadManifest reads the manifest of dir in s, it is empty if not written yet.
+func LoadManifest(s fs.Storage, dir string) (*Manifest, error) {
+	mf := &Manifest{
+		Files:   make(map[string]*ManifestEntry),
+		storage: s,
+		path:    fs.Join(s, dir, MetaDir, manifestFile),
+	}
+
+	r, err := s.Open(mf.path)
+	if os.IsNotExist(err) {
+		return mf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(mf); err != nil {
+		return nil, err
+	}
+	if mf.Files == nil {
+		mf.Files = make(map[string]*ManifestEntry)
+	}
+	return mf, nil
+}
+
+func (mf *Manifest) Save() error {
+	w, err := mf.storage.Create(mf.path, time.Time{})
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(mf); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Unchanged reports whether info is of the file as e was taken, by its size and modification time.
+func (e *ManifestEntry) Unchanged(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime.Equal(info.ModTime())
+}
+
+// Sums gives the files of idx, which indexes the directory of the manifest, their checksums of the manifest
+// if they are unchanged since, so they are not read.
+func (mf *Manifest) Sums(idx *Index) error {
+	for fullPath, m := range idx.media {
+		if len(m.SHA256) > 0 {
+			continue
+		}
+		rel, err := fs.Rel(idx.storage, idx.dir, fullPath)
+		if err != nil {
+			return err
+		}
+		if e, ok := mf.Files[rel]; ok && e.Unchanged(m.FileInfo) {
+			m.SHA256, _ = hex.DecodeString(e.SHA256)
+		}
+	}
+	return nil
+}
+
+// entryInfo is the file info of an entry, for the files known without a stat
+type entryInfo struct {
+	name string
+	e    *ManifestEntry
+}
+
+func (fi *entryInfo) Name() string       { return fi.name }
+func (fi *entryInfo) Size() int64        { return fi.e.Size }
+func (fi *entryInfo) Mode() os.FileMode  { return 0644 }
+func (fi *entryInfo) ModTime() time.Time { return fi.e.ModTime }
+func (fi *entryInfo) IsDir() bool        { return false }
+func (fi *entryInfo) Sys() interface{}   { return nil }
+
+// NewEntriesIndex indexes the files of dir in s by their entries of relative paths, without listing them,
+// their checksums are taken if known.
+func NewEntriesIndex(s fs.Storage, dir string, files map[string]*ManifestEntry) *Index {
+	idx := NewStorageIndex(s)
+	idx.dir = dir
+	for rel, e := range files {
+		if e.Size <= 0 {
+			continue
+		}
+		m := newStorageMedium(s, fs.Join(s, dir, rel), &entryInfo{name: path.Base(rel), e: e})
+		m.SHA256, _ = hex.DecodeString(e.SHA256)
+		idx.add(m)
+	}
+	return idx
+}
+
+// Entries returns the files of idx by their paths relative to its directory, with the checksums known.
+func (idx *Index) Entries() (map[string]*ManifestEntry, error) {
+	files := make(map[string]*ManifestEntry, len(idx.media))
+	for fullPath, m := range idx.media {
+		rel, err := fs.Rel(idx.storage, idx.dir, fullPath)
+		if err != nil {
+			return nil, err
+		}
+		files[rel] = &ManifestEntry{SHA256: hex.EncodeToString(m.SHA256), Size: m.FileInfo.Size(), ModTime: m.FileInfo.ModTime()}
+	}
+	return files, nil
+}
+
+// Move is a file of the manifest found at another path by its content.
+type Move struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Identify gives the unchanged files of idx, which indexes the directory of the manifest, their checksums of the manifest,
+// and follows the files moved or renamed within the directory by their contents. The moves are applied to the manifest.
+func (mf *Manifest) Identify(idx *Index) ([]Move, error) {
+	if err := mf.Sums(idx); err != nil {
+		return nil, err
+	}
+
+	// the files of the manifest which are gone, by size
+	gone := make(map[int64][]string)
+	present := make(map[string]bool)
+	var added []*Medium
+	for fullPath, m := range idx.media {
+		rel, err := fs.Rel(idx.storage, idx.dir, fullPath)
+		if err != nil {
+			return nil, err
+		}
+		present[rel] = true
+
+		if _, ok := mf.Files[rel]; !ok {
+			added = append(added, m)
+		}
+	}
+	for rel, e := range mf.Files {
+		if !present[rel] {
+			gone[e.Size] = append(gone[e.Size], rel)
+		}
+	}
+	if len(gone) == 0 {
+		return nil, nil
+	}
+	for _, rels := range gone {
+		sort.Strings(rels)
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].FullPath < added[j].FullPath })
+
+	var moves []Move
+	for _, m := range added {
+		rels := gone[m.FileInfo.Size()]
+		if len(rels) == 0 {
+			continue
+		}
+		m.SumSHA256()
+		sum := hex.EncodeToString(m.SHA256)
+		for i, rel := range rels {
+			e := mf.Files[rel]
+			if e.SHA256 != sum {
+				continue
+			}
+
+			to, err := fs.Rel(idx.storage, idx.dir, m.FullPath)
+			if err != nil {
+				return nil, err
+			}
+			delete(mf.Files, rel)
+			e.ModTime = m.FileInfo.ModTime()
+			mf.Files[to] = e
+			gone[e.Size] = append(rels[:i], rels[i+1:]...)
+			moves = append(moves, Move{From: fs.Join(idx.storage, idx.dir, rel), To: m.FullPath})
+			break
+		}
+	}
+	return moves, nil
+}
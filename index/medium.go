@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +21,10 @@ import (
 	"github.com/enjoypi/gordiff/wrapper"
 	"github.com/icedream/go-bsdiff"
 	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/geo"
+	"github.com/enjoypi/bkpic/progress"
 )
 
 const (
@@ -30,49 +35,112 @@ const (
 )
 
 var (
-	exiftoolFlags = []string{"-a", "-charset", "filename=UTF8", "-d", "%s", "-ee", "--ext", "json", "-G", "-j", "-L", "-q", "-r", "-sort"}
-	validDataTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	exiftoolFlags = []string{"-a", "-charset", "filename=UTF8", "-ee", "--ext", "json", "-G", "-j", "-L", "-q", "-r", "-sort"}
+	validDataTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
 )
 
+// Text is a value of exiftool, which prints numeric strings as numbers.
+type Text string
+
+func (t *Text) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = Text(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*t = Text(n.String())
+	return nil
+}
+
 type Meta struct {
-	SourceFile string `json:SourceFile`
+	SourceFile string `json:"SourceFile"`
 	//Directory      string `json:"File:Directory"`
-	FileModifyDate int64 `json:"File:FileModifyDate"` // third
-	FileCreateDate int64 `json:"File:FileCreateDate"` // third
+	FileModifyDate string `json:"File:FileModifyDate"` // third
+	FileCreateDate string `json:"File:FileCreateDate"` // third
 	//FileName       string `json:"File:FileName"`
 	FileType    string `json:"File:FileType"`
 	ImageHeight int64  `json:"File:ImageHeight"`
 	ImageWidth  int64  `json:"File:ImageWidth"`
 	MIMEType    string `json:"File:MIMEType"`
 
-	EXIFCreateDate       int64  `json:"EXIF:CreateDate"` // second
-	EXIFModifyDate       int64  `json:"EXIF:ModifyDate"`
-	DateTimeOriginal     int64  `json:"EXIF:DateTimeOriginal"`     // first
+	EXIFCreateDate       string `json:"EXIF:CreateDate"` // second
+	EXIFModifyDate       string `json:"EXIF:ModifyDate"`
+	DateTimeOriginal     string `json:"EXIF:DateTimeOriginal"`     // first
+	OffsetTimeOriginal   string `json:"EXIF:OffsetTimeOriginal"`   // offset of DateTimeOriginal
+	OffsetTimeDigitized  string `json:"EXIF:OffsetTimeDigitized"`  // offset of CreateDate
 	Model                string `json:"EXIF:Model"`                //  camera model
-	H264DateTimeOriginal int64  `json:"H264:DateTimeOriginal"`     // DateTime for h264
-	QTDateTime           int64  `json:"QuickTime:MediaCreateDate"` // DateTime for QuickTime
+	EXIFOrientation      Text   `json:"EXIF:Orientation"`          // how the image is rotated or flipped to display
+	H264DateTimeOriginal string `json:"H264:DateTimeOriginal"`     // DateTime for h264
+	QTDateTime           string `json:"QuickTime:MediaCreateDate"` // DateTime for QuickTime, in UTC
+	QTCreationDate       string `json:"QuickTime:CreationDate"`    // local DateTime with offset for QuickTime
 	XMPPhotoId           string `json:"XMP:PhotoId"`
 
 	GPSLatitude  string `json:"Composite:GPSLatitude"`
 	GPSLongitude string `json:"Composite:GPSLongitude"`
+	GPSDateTime  string `json:"Composite:GPSDateTime"` // in UTC
+
+	SerialNumber         Text `json:"EXIF:SerialNumber"`
+	MakerSerialNumber    Text `json:"MakerNotes:SerialNumber"`
+	InternalSerialNumber Text `json:"MakerNotes:InternalSerialNumber"`
 
 	// ExifTool Error
 	ExifToolError   string `json:"ExifTool:Error"`
 	ExifToolWarning string `json:"ExifTool:Warning"`
 }
 
+// orientations are the values of EXIF Orientation printed by exiftool, from 1
+var orientations = []string{
+	"Horizontal (normal)",
+	"Mirror horizontal",
+	"Rotate 180",
+	"Mirror vertical",
+	"Mirror horizontal and rotate 270 CW",
+	"Rotate 90 CW",
+	"Mirror horizontal and rotate 90 CW",
+	"Rotate 270 CW",
+}
+
+// Orientation returns the EXIF orientation of the image from 1 to 8, 1 is normal and also for the unknown.
+func (meta *Meta) Orientation() int {
+	value := strings.TrimSpace(string(meta.EXIFOrientation))
+	for i, o := range orientations {
+		if strings.EqualFold(value, o) {
+			return i + 1
+		}
+	}
+	if n, err := strconv.Atoi(value); err == nil && n >= 1 && n <= len(orientations) {
+		return n
+	}
+	return 1
+}
+
+// Serial returns the serial number of the camera.
+func (meta *Meta) Serial() string {
+	for _, serial := range []Text{meta.SerialNumber, meta.MakerSerialNumber, meta.InternalSerialNumber} {
+		if serial != "" {
+			return strings.TrimSpace(string(serial))
+		}
+	}
+	return ""
+}
+
 type Medium struct {
 	// meta info by exiftool
 	meta     *Meta
 	metaDone bool
 
-	//
-	//ShootingTime     time.Time
-	//ShootingTimeUnix int64
+	shooting *ShootingTime
+
 	Adler32  uint32
 	SHA256   []byte
 	FullPath string
 	os.FileInfo
+	storage   fs.Storage
 	imageHash *goimagehash.ImageHash
 	signFile  string
 }
@@ -83,12 +151,30 @@ func NewMedium(filename string) *Medium {
 		return nil
 	}
 
-	stat, err := os.Stat(abs)
+	return NewStorageMedium(fs.NewLocal(), abs)
+}
+
+// NewStorageMedium returns the medium of path in s, or nil if it is not a file.
+func NewStorageMedium(s fs.Storage, path string) *Medium {
+	stat, err := s.Stat(path)
 	if err != nil || stat == nil || stat.IsDir() || stat.Size() <= 0 {
 		return nil
 	}
+	return newStorageMedium(s, path, stat)
+}
+
+func newStorageMedium(s fs.Storage, path string, info os.FileInfo) *Medium {
+	return &Medium{FullPath: path, FileInfo: info, storage: s}
+}
+
+// Storage returns where the medium is kept.
+func (m *Medium) Storage() fs.Storage {
+	return m.storage
+}
 
-	return &Medium{FullPath: abs, FileInfo: stat}
+// Open opens the content of the medium.
+func (m *Medium) Open() (io.ReadCloser, error) {
+	return m.storage.Open(m.FullPath)
 }
 
 func (m *Medium) Meta() *Meta {
@@ -104,12 +190,30 @@ func (m *Medium) Meta() *Meta {
 
 	args := append(exiftoolFlags, m.FullPath)
 	cmd := exec.Command("exiftool", args...)
+	if !fs.IsLocal(m.storage) {
+		file, err := m.Open()
+		if err != nil {
+			zap.L().Info("open file", zap.Error(err), zap.String("file", m.FullPath))
+			return nil
+		}
+		defer file.Close()
+
+		// exiftool reads the content from stdin
+		cmd = exec.Command("exiftool", append(exiftoolFlags, "-")...)
+		cmd.Stdin = file
+	}
 
 	out, err := cmd.CombinedOutput()
+	m.meta = decodeMeta(out, err, m.FullPath)
+	return m.meta
+}
+
+// decodeMeta decodes the output of exiftool about file, nil if it tells no meta
+func decodeMeta(out []byte, err error, file string) *Meta {
 	if len(out) <= 0 {
 		zap.L().Info("exiftool err",
 			zap.Error(err),
-			zap.String("file", m.FullPath))
+			zap.String("file", file))
 		return nil
 	}
 
@@ -118,12 +222,12 @@ func (m *Medium) Meta() *Meta {
 	if err := decoder.Decode(&meta); err != nil {
 		zap.L().Debug(string(out),
 			zap.Error(err),
-			zap.String("file", m.FullPath))
+			zap.String("file", file))
 		return nil
 	}
 
 	if len(meta) <= 0 {
-		zap.L().Debug("invalid meta", zap.String("file", m.FullPath))
+		zap.L().Debug("invalid meta", zap.String("file", file))
 		return nil
 	}
 
@@ -131,12 +235,35 @@ func (m *Medium) Meta() *Meta {
 	if mt.ExifToolError != "" {
 		zap.L().Debug(mt.ExifToolError,
 			zap.String("detail", mt.ExifToolWarning),
-			zap.String("file", m.FullPath))
+			zap.String("file", file))
 		return nil
 	}
+	return mt
+}
 
-	m.meta = mt
-	return m.meta
+// readOnce takes the meta and the SHA256 of the medium by one read, for the storages read once, like a tar
+func (m *Medium) readOnce() {
+	file, err := m.Open()
+	if err != nil {
+		zap.L().Info("open file", zap.Error(err), zap.String("file", m.FullPath))
+		return
+	}
+	defer file.Close()
+
+	// exiftool may stop before the end, the rest is hashed after
+	h := sha256.New()
+	cmd := exec.Command("exiftool", append(exiftoolFlags, "-")...)
+	cmd.Stdin = io.TeeReader(file, h)
+	out, err := cmd.CombinedOutput()
+	m.meta = decodeMeta(out, err, m.FullPath)
+	m.metaDone = true
+
+	if _, err := io.Copy(h, file); err != nil {
+		zap.L().Info("read file", zap.Error(err), zap.String("file", m.FullPath))
+		return
+	}
+	m.SHA256 = h.Sum(nil)
+	progress.Add(progress.Hashed, 1, m.FileInfo.Size())
 }
 
 func (m *Medium) Valid() bool {
@@ -149,48 +276,103 @@ func (m *Medium) Valid() bool {
 		strings.HasPrefix(m.meta.MIMEType, videoPrefix)
 }
 
-func (m *Medium) ShootingTime() int64 {
+func (m *Medium) ShootingTime() ShootingTime {
+	if m.shooting != nil {
+		return *m.shooting
+	}
+
+	// the meta is loaded by shootingTime
+	shooting := m.shootingTime()
+	shooting = correctClock(m.Meta(), shooting)
+	m.shooting = &shooting
+	return shooting
+}
+
+func (m *Medium) shootingTime() ShootingTime {
 	meta := m.Meta()
 	if meta == nil {
-		return 0
+		return ShootingTime{}
+	}
+
+	if t, hasOffset, ok := parseTime(meta.DateTimeOriginal, DefaultLocation); ok && valid(t) {
+		if hasOffset {
+			return ShootingTime{Time: t, Source: SourceDateTimeOriginal, Zone: ZoneRecorded}
+		}
+		return localShootingTime(t, SourceDateTimeOriginal, meta.OffsetTimeOriginal, meta.GPSDateTime)
+	}
+
+	if t, hasOffset, ok := parseTime(meta.H264DateTimeOriginal, DefaultLocation); ok && valid(t) {
+		if hasOffset {
+			return ShootingTime{Time: t, Source: SourceH264, Zone: ZoneRecorded}
+		}
+		return localShootingTime(t, SourceH264, "", meta.GPSDateTime)
+	}
+
+	// QuickTime dates are in UTC
+	if t, _, ok := parseTime(meta.QTDateTime, time.UTC); ok && valid(t) {
+		// the CreationDate of Apple carries the offset
+		if created, hasOffset, ok := parseTime(meta.QTCreationDate, DefaultLocation); ok && hasOffset {
+			return ShootingTime{Time: t.In(created.Location()), Source: SourceQuickTime, Zone: ZoneRecorded}
+		}
+		return ShootingTime{Time: t.In(DefaultLocation), Source: SourceQuickTime, Zone: ZoneDefault}
 	}
 
-	if meta.DateTimeOriginal > validDataTime {
-		return m.meta.DateTimeOriginal
+	if t, hasOffset, ok := parseTime(meta.EXIFCreateDate, DefaultLocation); ok && valid(t) {
+		if hasOffset {
+			return ShootingTime{Time: t, Source: SourceCreateDate, Zone: ZoneRecorded}
+		}
+		return localShootingTime(t, SourceCreateDate, meta.OffsetTimeDigitized, meta.GPSDateTime)
 	}
 
-	if meta.H264DateTimeOriginal > validDataTime {
-		return meta.H264DateTimeOriginal
+	if t := extractTime(m.FileInfo.Name()); valid(t) {
+		return localShootingTime(t, SourceFileName, "", "")
 	}
 
-	if meta.QTDateTime > validDataTime {
-		return meta.QTDateTime
+	// file dates are instants, shown in the default zone
+	modify, modifyOK := ParseTime(meta.FileModifyDate)
+	modifyOK = modifyOK && valid(modify)
+	create, createOK := ParseTime(meta.FileCreateDate)
+	createOK = createOK && valid(create)
+
+	if modifyOK && createOK && modify.After(create) {
+		return ShootingTime{Time: create.In(DefaultLocation), Source: SourceFileCreateDate, Zone: ZoneDefault}
 	}
 
-	if meta.EXIFCreateDate > validDataTime {
-		return meta.EXIFCreateDate
+	if modifyOK {
+		return ShootingTime{Time: modify.In(DefaultLocation), Source: SourceFileModifyDate, Zone: ZoneDefault}
 	}
 
-	timeFromFilename := extractTime(m.FileInfo.Name())
-	if timeFromFilename > validDataTime {
-		return timeFromFilename
+	if createOK {
+		return ShootingTime{Time: create.In(DefaultLocation), Source: SourceFileCreateDate, Zone: ZoneDefault}
 	}
+	return ShootingTime{}
+}
 
-	if meta.FileModifyDate > meta.FileCreateDate && meta.FileCreateDate > validDataTime {
-		return meta.FileCreateDate
+// GPS returns the coordinates where the medium was shot.
+func (m *Medium) GPS() (geo.Coordinates, bool) {
+	meta := m.Meta()
+	if meta == nil {
+		return geo.Coordinates{}, false
 	}
 
-	if meta.FileModifyDate > validDataTime {
-		return meta.FileModifyDate
+	lat, latOK := geo.ParseCoordinate(meta.GPSLatitude)
+	lon, lonOK := geo.ParseCoordinate(meta.GPSLongitude)
+	if !latOK || !lonOK || (lat == 0 && lon == 0) {
+		return geo.Coordinates{}, false
 	}
+	return geo.Coordinates{Latitude: lat, Longitude: lon}, true
+}
 
-	if meta.FileCreateDate > validDataTime {
-		return meta.FileCreateDate
+// Place returns the nearest city where the medium was shot.
+func (m *Medium) Place() (geo.Place, bool) {
+	c, ok := m.GPS()
+	if !ok {
+		return geo.Place{}, false
 	}
-	return 0
+	return geo.Lookup(c)
 }
 
-func extractTime(filename string) int64 {
+func extractTime(filename string) time.Time {
 	// try:
 	// 	return datetime.strptime(time_str, "%Y:%m:%d %H:%M:%S"), True
 	// except Exception:
@@ -220,7 +402,7 @@ func extractTime(filename string) int64 {
 	// 	pass
 
 	// return None, False
-	return 0
+	return time.Time{}
 }
 
 func (m *Medium) SumAdler32() {
@@ -228,7 +410,7 @@ func (m *Medium) SumAdler32() {
 		return
 	}
 
-	file, err := os.Open(m.FullPath)
+	file, err := m.Open()
 	if err != nil {
 		zap.L().Error("open file", zap.Error(err))
 		return
@@ -242,7 +424,7 @@ func (m *Medium) SumAdler32() {
 		data = make([]byte, m.FileInfo.Size())
 	}
 
-	_, err = file.Read(data)
+	_, err = io.ReadFull(file, data)
 	if err != nil {
 		zap.L().Error("read file", zap.Error(err))
 		return
@@ -252,7 +434,20 @@ func (m *Medium) SumAdler32() {
 }
 
 func (m *Medium) SumSHA256() {
-	file, err := os.Open(m.FullPath)
+	if len(m.SHA256) > 0 {
+		return
+	}
+
+	if c, ok := m.storage.(fs.Checksummer); ok {
+		if sum, err := c.SHA256(m.FullPath); err != nil {
+			zap.L().Info("stored checksum", zap.String("file", m.FullPath), zap.Error(err))
+		} else if len(sum) > 0 {
+			m.SHA256 = sum
+			return
+		}
+	}
+
+	file, err := m.Open()
 	if err != nil {
 		zap.L().Error("open file", zap.Error(err))
 		return
@@ -266,6 +461,7 @@ func (m *Medium) SumSHA256() {
 	}
 
 	m.SHA256 = h.Sum(nil)
+	progress.Add(progress.Hashed, 1, m.FileInfo.Size())
 }
 
 func (m *Medium) PHash() error {
@@ -273,7 +469,7 @@ func (m *Medium) PHash() error {
 		return nil
 	}
 
-	file, err := os.Open(m.FullPath)
+	file, err := m.Open()
 	if err != nil {
 		zap.L().Info("open file", zap.Error(err))
 		return err
@@ -303,6 +499,25 @@ func (m *Medium) Same(other *Medium) bool {
 	if m.FileInfo.Size() != other.FileInfo.Size() {
 		return false
 	}
+	progress.Add(progress.Compared, 1, 0)
+
+	// by the stored checksums, not to read the files
+	if m.checksummed() || other.checksummed() {
+		if m.Identical(other) {
+			return true
+		}
+		return m.same(other)
+	}
+
+	// by the known checksum, which is taken from the manifest, not to read the other file twice
+	if len(m.SHA256) > 0 || len(other.SHA256) > 0 {
+		m.SumSHA256()
+		other.SumSHA256()
+		if len(m.SHA256) > 0 && bytes.Equal(m.SHA256, other.SHA256) {
+			return true
+		}
+		return m.same(other)
+	}
 
 	m.SumAdler32()
 	other.SumAdler32()
@@ -325,6 +540,23 @@ func (m *Medium) Same(other *Medium) bool {
 	return true
 }
 
+// checksummed reports whether the storage of m keeps the checksums of its files
+func (m *Medium) checksummed() bool {
+	_, ok := m.storage.(fs.Checksummer)
+	return ok
+}
+
+// Identical reports whether other has exactly the same content.
+func (m *Medium) Identical(other *Medium) bool {
+	if m.FileInfo.Size() != other.FileInfo.Size() {
+		return false
+	}
+
+	m.SumSHA256()
+	other.SumSHA256()
+	return len(m.SHA256) > 0 && bytes.Equal(m.SHA256, other.SHA256)
+}
+
 func (m *Medium) same(other *Medium) bool {
 	if !m.Valid() {
 		return false
@@ -376,18 +608,20 @@ func (m *Medium) sameMeta(other *Medium) bool {
 	if meta.Model != "" && meta.Model == otherMeta.Model &&
 		meta.ImageHeight > 0 && meta.ImageHeight == otherMeta.ImageHeight &&
 		meta.ImageWidth > 0 && meta.ImageWidth == otherMeta.ImageWidth &&
-		m.ShootingTime() > 0 && m.ShootingTime() == other.ShootingTime() {
+		m.ShootingTime().Valid() && m.ShootingTime().Equal(other.ShootingTime().Time) {
 		return true
 	}
 	return false
 }
 
 func (m *Medium) sameChunk(other *Medium) bool {
-	if m.signFile == "" {
+	// librsync works on local files only
+	local := fs.IsLocal(m.storage) && fs.IsLocal(other.storage)
+	if local && m.signFile == "" {
 		m.signFile, _ = wrapper.RSSig(m.FullPath)
 	}
 
-	if m.signFile != "" {
+	if local && m.signFile != "" {
 		if deltaPath, err := wrapper.RSDelta(m.FullPath, m.signFile, other.FullPath); err == nil {
 			if stat, err := os.Stat(deltaPath); err == nil {
 				ratio := float64(stat.Size()) / float64(m.FileInfo.Size())
@@ -401,14 +635,14 @@ func (m *Medium) sameChunk(other *Medium) bool {
 		}
 	}
 
-	file, err := os.Open(m.FullPath)
+	file, err := m.Open()
 	if err != nil {
 		zap.L().Info("open file", zap.Error(err))
 		return false
 	}
 	defer file.Close()
 
-	ofile, err := os.Open(other.FullPath)
+	ofile, err := other.Open()
 	if err != nil {
 		zap.L().Info("open file", zap.Error(err))
 		return false
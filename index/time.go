@@ -0,0 +1,182 @@
+package index
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	exifTimeLayout        = "2006:01:02 15:04:05"
+	exifTimeLayoutZone    = "2006:01:02 15:04:05Z07:00"
+	exifTimeLayoutNumZone = "2006:01:02 15:04:05-0700"
+	offsetLayout          = "Z07:00"
+
+	// GPS fixes are not taken at the shutter, so the offset from them is rounded
+	gpsOffsetRound = 15 * time.Minute
+	maxZoneOffset  = 14 * time.Hour
+)
+
+// DefaultLocation is the zone of the local times without offset.
+var DefaultLocation = time.Local
+
+// TimeSource is the tag which the shooting time is taken from.
+type TimeSource string
+
+const (
+	SourceDateTimeOriginal TimeSource = "EXIF:DateTimeOriginal"
+	SourceH264             TimeSource = "H264:DateTimeOriginal"
+	SourceQuickTime        TimeSource = "QuickTime:MediaCreateDate"
+	SourceCreateDate       TimeSource = "EXIF:CreateDate"
+	SourceFileName         TimeSource = "FileName"
+	SourceFileCreateDate   TimeSource = "File:FileCreateDate"
+	SourceFileModifyDate   TimeSource = "File:FileModifyDate"
+)
+
+// Confidence is how much the source of a shooting time can be trusted.
+type Confidence int
+
+const (
+	ConfidenceNone Confidence = iota
+	// ConfidenceLow is of the file dates, which change by copying and editing
+	ConfidenceLow
+	// ConfidenceMedium is of the dates in file names
+	ConfidenceMedium
+	// ConfidenceHigh is of the dates recorded by cameras
+	ConfidenceHigh
+)
+
+var confidenceNames = []string{"none", "low", "medium", "high"}
+
+func (c Confidence) String() string {
+	if c < 0 || int(c) >= len(confidenceNames) {
+		return fmt.Sprintf("Confidence(%d)", int(c))
+	}
+	return confidenceNames[c]
+}
+
+// ParseConfidence parses the name of a confidence level.
+func ParseConfidence(name string) (Confidence, error) {
+	for i, n := range confidenceNames {
+		if n == name {
+			return Confidence(i), nil
+		}
+	}
+	return ConfidenceNone, fmt.Errorf("invalid confidence %q", name)
+}
+
+// ZoneSource tells where the offset of the shooting time comes from.
+type ZoneSource string
+
+const (
+	// ZoneRecorded is the offset recorded with the time, or in an EXIF offset tag
+	ZoneRecorded ZoneSource = "recorded"
+	// ZoneGPS is the offset between the local time and the GPS time in UTC
+	ZoneGPS ZoneSource = "gps"
+	// ZoneDefault is DefaultLocation, assumed for local times without offset
+	ZoneDefault ZoneSource = "default"
+)
+
+// ShootingTime is when a medium was shot, in the zone where it was shot.
+type ShootingTime struct {
+	time.Time
+	Source TimeSource
+	Zone   ZoneSource
+	// Correction is added to the camera time by the clock corrections
+	Correction time.Duration
+}
+
+// Valid reports whether the shooting time is known.
+func (t ShootingTime) Valid() bool {
+	return !t.Time.IsZero()
+}
+
+// Confidence returns the confidence level of the source.
+func (t ShootingTime) Confidence() Confidence {
+	if !t.Valid() {
+		return ConfidenceNone
+	}
+
+	switch t.Source {
+	case SourceDateTimeOriginal, SourceH264, SourceQuickTime, SourceCreateDate:
+		return ConfidenceHigh
+	case SourceFileName:
+		return ConfidenceMedium
+	default:
+		return ConfidenceLow
+	}
+}
+
+// ParseTime parses the date of exiftool, a date without offset is in DefaultLocation.
+func ParseTime(value string) (time.Time, bool) {
+	t, _, ok := parseTime(value, DefaultLocation)
+	return t, ok
+}
+
+// parseTime parses the date in loc if it has no offset, and reports whether it carries the offset
+func parseTime(value string, loc *time.Location) (time.Time, bool, bool) {
+	if value == "" {
+		return time.Time{}, false, false
+	}
+
+	for _, layout := range []string{exifTimeLayoutZone, exifTimeLayoutNumZone} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true, true
+		}
+	}
+
+	t, err := time.ParseInLocation(exifTimeLayout, value, loc)
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	return t, false, true
+}
+
+func valid(t time.Time) bool {
+	return t.After(validDataTime)
+}
+
+// parseOffset parses the EXIF offset tags like +09:00
+func parseOffset(value string) (*time.Location, bool) {
+	if value == "" {
+		return nil, false
+	}
+	t, err := time.Parse(offsetLayout, value)
+	if err != nil {
+		return nil, false
+	}
+	return t.Location(), true
+}
+
+// gpsLocation guesses the zone of the local time from the GPS time in UTC
+func gpsLocation(local time.Time, gps string) (*time.Location, bool) {
+	gpsTime, _, ok := parseTime(gps, time.UTC)
+	if !ok || !valid(gpsTime) {
+		return nil, false
+	}
+
+	asUTC := time.Date(local.Year(), local.Month(), local.Day(),
+		local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+	offset := asUTC.Sub(gpsTime).Round(gpsOffsetRound)
+	if offset < -maxZoneOffset || offset > maxZoneOffset {
+		return nil, false
+	}
+	return time.FixedZone("", int(offset.Seconds())), true
+}
+
+// localShootingTime places a local time without offset in the zone by offset tag, GPS time or DefaultLocation
+func localShootingTime(local time.Time, source TimeSource, offset string, gps string) ShootingTime {
+	in := func(loc *time.Location) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day(),
+			local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), loc)
+	}
+
+	if loc, ok := parseOffset(offset); ok {
+		return ShootingTime{Time: in(loc), Source: source, Zone: ZoneRecorded}
+	}
+
+	if loc, ok := gpsLocation(local, gps); ok {
+		return ShootingTime{Time: in(loc), Source: source, Zone: ZoneGPS}
+	}
+
+	return ShootingTime{Time: in(DefaultLocation), Source: source, Zone: ZoneDefault}
+}
@@ -0,0 +1,645 @@
+// Package s3d is a fake S3 server for testing the s3 storage, by the tests of fs,
+// and by the s3d command built into bkpic by -tags s3d.
+package s3d
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// metaDir keeps the ETags, the user metadata and the tags of the objects, and the multipart uploads
+const metaDir = ".s3d"
+
+type Config struct {
+	Addr string
+}
+
+// Run serves dir as a local stand-in of S3 for testing, the buckets are its subdirectories.
+// It does not check the signatures of requests, but verifies their payload checksums.
+func Run(c *Config, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	h, err := NewHandler(dir)
+	if err != nil {
+		return err
+	}
+
+	zap.L().Info("serving S3", zap.String("address", c.Addr), zap.String("directory", dir))
+	return http.ListenAndServe(c.Addr, h)
+}
+
+// NewHandler serves dir as S3, the buckets are its subdirectories.
+func NewHandler(dir string) (http.Handler, error) {
+	if err := os.MkdirAll(filepath.Join(dir, metaDir), os.FileMode(0700)); err != nil {
+		return nil, err
+	}
+	return &server{dir: dir}, nil
+}
+
+type server struct {
+	dir string
+}
+
+// object is the sidecar of an object
+type object struct {
+	ETag string            `json:"etag"`
+	Meta map[string]string `json:"meta,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+type s3Error struct {
+	status  int
+	Code    string
+	Message string
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errNoSuchKey    = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	errNoSuchUpload = &s3Error{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist."}
+	errBadDigest    = &s3Error{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what was received."}
+	errBadSHA256    = &s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}
+	errInvalidPart  = &s3Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found."}
+)
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := splitPath(r.URL.Path)
+	zap.L().Debug("s3d", zap.String("method", r.Method), zap.String("bucket", bucket),
+		zap.String("key", key), zap.String("query", r.URL.RawQuery))
+
+	var err error
+	if bucket == "" || strings.HasPrefix(bucket, ".") || strings.Contains(key, "..") {
+		err = &s3Error{http.StatusBadRequest, "InvalidRequest", "Invalid bucket or key."}
+	} else {
+		err = s.serve(w, r, bucket, key)
+	}
+	if err == nil {
+		return
+	}
+
+	e, ok := err.(*s3Error)
+	if !ok {
+		if os.IsNotExist(err) {
+			e = errNoSuchKey
+		} else {
+			e = &s3Error{http.StatusInternalServerError, "InternalError", err.Error()}
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		_ = xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"Error"`
+			Code    string
+			Message string
+		}{Code: e.Code, Message: e.Message})
+	}
+}
+
+func (s *server) serve(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	query := r.URL.Query()
+	if key == "" {
+		switch r.Method {
+		case http.MethodPut:
+			return os.MkdirAll(filepath.Join(s.dir, bucket), os.FileMode(0700))
+		case http.MethodGet:
+			return s.list(w, bucket, query)
+		}
+		return &s3Error{http.StatusNotImplemented, "NotImplemented", r.Method + " bucket"}
+	}
+
+	_, tagging := query["tagging"]
+	_, uploads := query["uploads"]
+	uploadID := query.Get("uploadId")
+	switch {
+	case tagging && r.Method == http.MethodGet:
+		return s.getTagging(w, bucket, key)
+	case tagging && r.Method == http.MethodPut:
+		return s.putTagging(r, bucket, key)
+	case uploads && r.Method == http.MethodPost:
+		return s.createUpload(w, r, bucket, key)
+	case uploadID != "" && r.Method == http.MethodPut:
+		return s.uploadPart(w, r, uploadID, query.Get("partNumber"))
+	case uploadID != "" && r.Method == http.MethodPost:
+		return s.completeUpload(w, r, bucket, key, uploadID)
+	case uploadID != "" && r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+		return os.RemoveAll(s.uploadDir(uploadID))
+	case r.Method == http.MethodHead, r.Method == http.MethodGet:
+		return s.get(w, r, bucket, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		return s.copy(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		return s.put(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		return s.remove(w, bucket, key)
+	}
+	return &s3Error{http.StatusNotImplemented, "NotImplemented", r.Method + " object"}
+}
+
+func splitPath(p string) (string, string) {
+	p = strings.TrimPrefix(p, "/")
+	i := strings.IndexByte(p, '/')
+	if i < 0 {
+		return p, ""
+	}
+	return p[:i], p[i+1:]
+}
+
+func (s *server) dataPath(bucket, key string) string {
+	return filepath.Join(s.dir, bucket, filepath.FromSlash(key))
+}
+
+func (s *server) metaPath(bucket, key string) string {
+	return filepath.Join(s.dir, metaDir, bucket, filepath.FromSlash(key)+".json")
+}
+
+func (s *server) uploadDir(id string) string {
+	return filepath.Join(s.dir, metaDir, "uploads", filepath.Base(id))
+}
+
+func (s *server) loadObject(bucket, key string) (*object, error) {
+	data, err := ioutil.ReadFile(s.metaPath(bucket, key))
+	if err != nil {
+		if _, err := os.Stat(s.dataPath(bucket, key)); err != nil {
+			return nil, errNoSuchKey
+		}
+		// put into the directory by others
+		return &object{}, nil
+	}
+	o := &object{}
+	return o, json.Unmarshal(data, o)
+}
+
+func (s *server) saveObject(bucket, key string, o *object) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	p := s.metaPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(p), os.FileMode(0700)); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, os.FileMode(0600))
+}
+
+// readBody reads the payload and verifies its checksums
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		sum := md5.Sum(data)
+		if v != base64.StdEncoding.EncodeToString(sum[:]) {
+			return nil, errBadDigest
+		}
+	}
+	if v := r.Header.Get("X-Amz-Content-Sha256"); v != "" && !strings.HasPrefix(v, "UNSIGNED") && !strings.HasPrefix(v, "STREAMING") {
+		sum := sha256.Sum256(data)
+		if v != hex.EncodeToString(sum[:]) {
+			return nil, errBadSHA256
+		}
+	}
+	return data, nil
+}
+
+func userMeta(h http.Header) map[string]string {
+	meta := make(map[string]string)
+	for k, v := range h {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+			meta[http.CanonicalHeaderKey(k)] = strings.Join(v, ",")
+		}
+	}
+	return meta
+}
+
+func parseTags(v string) (map[string]string, error) {
+	values, err := url.ParseQuery(v)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for k := range values {
+		tags[k] = values.Get(k)
+	}
+	return tags, nil
+}
+
+func (s *server) writeFile(bucket, key string, data io.Reader) (string, error) {
+	p := s.dataPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(p), os.FileMode(0700)); err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(p), ".s3d-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(file, h), data); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), os.Rename(file.Name(), p)
+}
+
+func (s *server) put(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	o := &object{Meta: userMeta(r.Header)}
+	if v := r.Header.Get("X-Amz-Tagging"); v != "" {
+		if o.Tags, err = parseTags(v); err != nil {
+			return err
+		}
+	}
+	if o.ETag, err = s.writeFile(bucket, key, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if err := s.saveObject(bucket, key, o); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", strconv.Quote(o.ETag))
+	return nil
+}
+
+func (s *server) copy(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return err
+	}
+	srcBucket, srcKey := splitPath(source)
+	o, err := s.loadObject(srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(s.dataPath(srcBucket, srcKey))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if o.ETag, err = s.writeFile(bucket, key, file); err != nil {
+		return err
+	}
+	if err := s.saveObject(bucket, key, o); err != nil {
+		return err
+	}
+	return writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: strconv.Quote(o.ETag), LastModified: time.Now().UTC().Format(time.RFC3339)})
+}
+
+func (s *server) get(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	o, err := s.loadObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(s.dataPath(bucket, key))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errNoSuchKey
+	}
+
+	for k, v := range o.Meta {
+		w.Header().Set(k, v)
+	}
+	if o.ETag != "" {
+		w.Header().Set("ETag", strconv.Quote(o.ETag))
+	}
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+func (s *server) remove(w http.ResponseWriter, bucket, key string) error {
+	if err := os.Remove(s.dataPath(bucket, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(s.metaPath(bucket, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []tag    `xml:"TagSet>Tag"`
+}
+
+type tag struct {
+	Key   string
+	Value string
+}
+
+func (s *server) getTagging(w http.ResponseWriter, bucket, key string) error {
+	o, err := s.loadObject(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	t := tagging{TagSet: []tag{}}
+	for k, v := range o.Tags {
+		t.TagSet = append(t.TagSet, tag{Key: k, Value: v})
+	}
+	sort.Slice(t.TagSet, func(i, j int) bool { return t.TagSet[i].Key < t.TagSet[j].Key })
+	return writeXML(w, t)
+}
+
+func (s *server) putTagging(r *http.Request, bucket, key string) error {
+	o, err := s.loadObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var t tagging
+	if err := xml.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	o.Tags = make(map[string]string)
+	for _, tag := range t.TagSet {
+		o.Tags[tag.Key] = tag.Value
+	}
+	return s.saveObject(bucket, key, o)
+}
+
+func (s *server) createUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	dir := s.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&object{Meta: userMeta(r.Header)})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "object.json"), data, os.FileMode(0600)); err != nil {
+		return err
+	}
+
+	return writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: bucket, Key: key, UploadId: uploadID})
+}
+
+func (s *server) uploadPart(w http.ResponseWriter, r *http.Request, uploadID string, partNumber string) error {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 {
+		return &s3Error{http.StatusBadRequest, "InvalidArgument", "Invalid part number."}
+	}
+	dir := s.uploadDir(uploadID)
+	if _, err := os.Stat(dir); err != nil {
+		return errNoSuchUpload
+	}
+
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(n)), data, os.FileMode(0600)); err != nil {
+		return err
+	}
+	sum := md5.Sum(data)
+	w.Header().Set("ETag", strconv.Quote(hex.EncodeToString(sum[:])))
+	return nil
+}
+
+func (s *server) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) error {
+	dir := s.uploadDir(uploadID)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "object.json"))
+	if err != nil {
+		return errNoSuchUpload
+	}
+	o := &object{}
+	if err := json.Unmarshal(data, o); err != nil {
+		return err
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var complete struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &complete); err != nil {
+		return err
+	}
+
+	var readers []io.Reader
+	sums := md5.New()
+	for _, p := range complete.Parts {
+		file, err := os.Open(filepath.Join(dir, strconv.Itoa(p.PartNumber)))
+		if err != nil {
+			return errInvalidPart
+		}
+		defer file.Close()
+
+		h := md5.New()
+		if _, err := io.Copy(h, file); err != nil {
+			return err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != strings.Trim(p.ETag, `"`) {
+			return errInvalidPart
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		sums.Write(h.Sum(nil))
+		readers = append(readers, file)
+	}
+
+	if _, err := s.writeFile(bucket, key, io.MultiReader(readers...)); err != nil {
+		return err
+	}
+	o.ETag = fmt.Sprintf("%x-%d", sums.Sum(nil), len(complete.Parts))
+	if err := s.saveObject(bucket, key, o); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	return writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: bucket, Key: key, ETag: strconv.Quote(o.ETag)})
+}
+
+type content struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+func (s *server) list(w http.ResponseWriter, bucket string, query url.Values) error {
+	root := filepath.Join(s.dir, bucket)
+	if _, err := os.Stat(root); err != nil {
+		return &s3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist."}
+	}
+
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := 1000
+	if v, err := strconv.Atoi(query.Get("max-keys")); err == nil && v >= 0 {
+		maxKeys = v
+	}
+	after := query.Get("continuation-token")
+	if after == "" {
+		after = query.Get("start-after")
+	}
+
+	var keys []string
+	sizes := make(map[string]os.FileInfo)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".s3d-") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+			sizes[key] = info
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+	}{Name: bucket, Prefix: prefix, MaxKeys: maxKeys}
+
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key <= after {
+			continue
+		}
+		if result.KeyCount >= maxKeys {
+			result.IsTruncated = true
+			break
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: p})
+					result.KeyCount++
+				}
+				// the next page starts after all the keys of the prefix
+				result.NextContinuationToken = p + "\xff"
+				continue
+			}
+		}
+
+		info := sizes[key]
+		var etag string
+		if o, err := s.loadObject(bucket, key); err == nil {
+			etag = o.ETag
+		}
+		result.Contents = append(result.Contents, content{Key: key, Size: info.Size(), ETag: strconv.Quote(etag),
+			LastModified: info.ModTime().UTC().Format(time.RFC3339)})
+		result.KeyCount++
+		result.NextContinuationToken = key
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	return writeXML(w, result)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/xml")
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append([]byte(xml.Header), data...))
+	return err
+}
@@ -0,0 +1,93 @@
+package parity
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTooManyErasures = errors.New("too many damaged blocks to repair")
+
+// Code is a systematic Reed–Solomon erasure code of data blocks and parity blocks by a Cauchy matrix,
+// any data blocks up to the number of parity blocks are reconstructed from the others.
+type Code struct {
+	data   int
+	parity int
+	// coef[p][i] is the coefficient of data block i in parity block p
+	coef [][]byte
+}
+
+func NewCode(data, parity int) (*Code, error) {
+	if data <= 0 || parity <= 0 || data+parity > 256 {
+		return nil, fmt.Errorf("invalid code of %d data blocks and %d parity blocks", data, parity)
+	}
+
+	c := &Code{data: data, parity: parity, coef: make([][]byte, parity)}
+	for p := range c.coef {
+		c.coef[p] = make([]byte, data)
+		for i := range c.coef[p] {
+			// 1 / (x_p + y_i) with distinct x_p = p and y_i = parity + i
+			c.coef[p][i] = gfInv(byte(p) ^ byte(parity+i))
+		}
+	}
+	return c, nil
+}
+
+// Add adds data block i to the parity blocks, which are all zero before the first one.
+func (c *Code) Add(parity [][]byte, i int, block []byte) {
+	for p := range parity {
+		mulAdd(parity[p], block, c.coef[p][i])
+	}
+}
+
+// Reconstruct fills the nil data blocks from the others and the parity blocks, nil parity blocks are damaged ones.
+// All blocks are of size, the positions of a stripe without data are zero blocks.
+func (c *Code) Reconstruct(data [][]byte, parity [][]byte, size int) error {
+	var lost []int
+	for i, block := range data {
+		if block == nil {
+			lost = append(lost, i)
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+
+	var rows []int
+	for p, block := range parity {
+		if block != nil && len(rows) < len(lost) {
+			rows = append(rows, p)
+		}
+	}
+	if len(rows) < len(lost) {
+		return ErrTooManyErasures
+	}
+
+	// the parity without the intact data blocks is the sum of the lost ones
+	rhs := make([][]byte, len(rows))
+	m := make([][]byte, len(rows))
+	for r, p := range rows {
+		rhs[r] = append([]byte(nil), parity[p]...)
+		for i, block := range data {
+			if block != nil {
+				mulAdd(rhs[r], block, c.coef[p][i])
+			}
+		}
+		m[r] = make([]byte, len(lost))
+		for j, i := range lost {
+			m[r][j] = c.coef[p][i]
+		}
+	}
+
+	inv := invert(m)
+	if inv == nil {
+		return ErrTooManyErasures
+	}
+	for j, i := range lost {
+		block := make([]byte, size)
+		for r := range rows {
+			mulAdd(block, rhs[r], inv[j][r])
+		}
+		data[i] = block
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package parity
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func randomBlocks(r *rand.Rand, n, size int) [][]byte {
+	blocks := make([][]byte, n)
+	for i := range blocks {
+		blocks[i] = make([]byte, size)
+		r.Read(blocks[i])
+	}
+	return blocks
+}
+
+func encode(c *Code, data [][]byte, size int) [][]byte {
+	parity := make([][]byte, c.parity)
+	for p := range parity {
+		parity[p] = make([]byte, size)
+	}
+	for i, block := range data {
+		c.Add(parity, i, block)
+	}
+	return parity
+}
+
+func TestNewCode(t *testing.T) {
+	tests := []struct {
+		data, parity int
+		ok           bool
+	}{
+		{20, 1, true},
+		{20, 20, true},
+		{128, 128, true},
+		{0, 2, false},
+		{20, 0, false},
+		{200, 57, false},
+	}
+	for _, tt := range tests {
+		if _, err := NewCode(tt.data, tt.parity); (err == nil) != tt.ok {
+			t.Errorf("NewCode(%d, %d) = %v, want ok %v", tt.data, tt.parity, err, tt.ok)
+		}
+	}
+}
+
+func TestCodeReconstruct(t *testing.T) {
+	const size = 64
+	r := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name   string
+		parity int
+		// lost data blocks and damaged parity blocks
+		lost, damaged []int
+		ok            bool
+	}{
+		{"intact", 2, nil, nil, true},
+		{"one lost", 1, []int{7}, nil, true},
+		{"first and last lost", 2, []int{0, 19}, nil, true},
+		{"lost and damaged parity", 4, []int{3, 4}, []int{0, 2}, true},
+		{"all parity used", 4, []int{1, 5, 9, 13}, nil, true},
+		{"as many lost as parity", 20, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}, nil, true},
+		{"too many lost", 2, []int{0, 1, 2}, nil, false},
+		{"too many with damaged parity", 4, []int{2, 3, 4}, []int{1, 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCode(stripeData, tt.parity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := randomBlocks(r, stripeData, size)
+			parity := encode(c, want, size)
+
+			data := append([][]byte(nil), want...)
+			for _, i := range tt.lost {
+				data[i] = nil
+			}
+			for _, p := range tt.damaged {
+				parity[p] = nil
+			}
+
+			err = c.Reconstruct(data, parity, size)
+			if !tt.ok {
+				if err != ErrTooManyErasures {
+					t.Errorf("Reconstruct() = %v, want %v", err, ErrTooManyErasures)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reconstruct() = %v", err)
+			}
+			for i := range want {
+				if !bytes.Equal(data[i], want[i]) {
+					t.Errorf("block %d is not reconstructed", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,596 @@
+package parity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+const (
+	// the parity of a directory is kept in its meta directory, the header and the parity blocks
+	headerFile = "parity.json"
+	blocksFile = "parity.dat"
+	repairExt  = ".repair"
+
+	DefaultBlockSize = 64 << 10
+	// the data blocks of a stripe, the parity blocks are by the redundancy
+	stripeData = 20
+	// the most data blocks of a set, which limits the parity in memory
+	maxSetBlocks = 4096
+)
+
+var (
+	ErrNoParity = errors.New("no parity")
+	crcTable    = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type Config struct {
+	// Redundancy is the size of parity in percent of the data, 0 disables parity
+	Redundancy int
+	// BlockSize is the unit of damage and repair
+	BlockSize int `mapstructure:"block"`
+}
+
+func (c *Config) check() error {
+	if c.Redundancy <= 0 || c.Redundancy > 100 {
+		return fmt.Errorf("invalid redundancy %d%%, expect 1 to 100", c.Redundancy)
+	}
+	if c.BlockSize <= 0 {
+		return fmt.Errorf("invalid block size %d", c.BlockSize)
+	}
+	return nil
+}
+
+// parity returns the parity blocks of a stripe
+func (c *Config) parity() int {
+	return (stripeData*c.Redundancy + 99) / 100
+}
+
+// header describes the files of a directory and the layout of their parity.
+// The blocks of the files in name order are numbered together and split into sets,
+// block j of a set is at position j / stripes of stripe j % stripes,
+// so the consecutive damaged blocks of a file fall into different stripes.
+//
+// A stripe is repaired if at most Parity of its blocks, data or parity, are damaged. So a set survives
+// one run of up to stripes * Parity damaged blocks, but scattered damage counts against a stripe
+// whenever the blocks are a multiple of stripes apart: Parity + 1 damaged blocks at j, j + stripes, ...
+// are lost with much less damage in total. No interleave avoids that, it only picks which blocks collide.
+type header struct {
+	BlockSize int        `json:"blockSize"`
+	Data      int        `json:"data"`
+	Parity    int        `json:"parity"`
+	Files     []fileInfo `json:"files"`
+	Sets      []set      `json:"sets"`
+}
+
+type fileInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+type set struct {
+	Blocks  int `json:"blocks"`
+	Stripes int `json:"stripes"`
+	// CRC and ParityCRC are the packed CRC32 of the data blocks and the parity blocks
+	CRC       []byte `json:"crc"`
+	ParityCRC []byte `json:"parityCRC"`
+}
+
+func (f *fileInfo) blocks(blockSize int) int {
+	return int((f.Size + int64(blockSize) - 1) / int64(blockSize))
+}
+
+func (h *header) numBlocks() int {
+	var n int
+	for i := range h.Files {
+		n += h.Files[i].blocks(h.BlockSize)
+	}
+	return n
+}
+
+// layout splits the blocks into sets
+func (h *header) layout() {
+	h.Sets = nil
+	for n := h.numBlocks(); n > 0; n -= maxSetBlocks {
+		blocks := n
+		if blocks > maxSetBlocks {
+			blocks = maxSetBlocks
+		}
+		h.Sets = append(h.Sets, set{Blocks: blocks, Stripes: (blocks + h.Data - 1) / h.Data})
+	}
+}
+
+func blockCRC(block []byte) uint32 {
+	return crc32.Checksum(block, crcTable)
+}
+
+func getCRC(packed []byte, i int) uint32 {
+	return binary.BigEndian.Uint32(packed[4*i:])
+}
+
+func appendCRC(packed []byte, crc uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], crc)
+	return append(packed, b[:]...)
+}
+
+// listFiles returns the files of dir in name order
+func listFiles(s fs.Storage, dir string) ([]fileInfo, error) {
+	entries, err := s.List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []fileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Size() <= 0 {
+			continue
+		}
+		files = append(files, fileInfo{Name: entry.Name(), Size: entry.Size(), ModTime: entry.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// readBlocks calls fn with every block of the files in order, g is its number and i is its index in file f.
+// The last block of a file is padded with zeros, the blocks which cannot be read are nil.
+func readBlocks(s fs.Storage, dir string, h *header, fn func(g, f, i int, block []byte) error) error {
+	var g int
+	for f := range h.Files {
+		file := &h.Files[f]
+		n := file.blocks(h.BlockSize)
+
+		r, err := s.Open(fs.Join(s, dir, file.Name))
+		if err != nil {
+			r = nil
+		}
+		for i := 0; i < n; i++ {
+			var block []byte
+			if r != nil {
+				block = make([]byte, h.BlockSize)
+				if _, err := io.ReadFull(r, block); err == io.EOF {
+					block = nil
+				} else if err != nil && err != io.ErrUnexpectedEOF {
+					block = nil
+				}
+			}
+			if err := fn(g, f, i, block); err != nil {
+				if r != nil {
+					r.Close()
+				}
+				return err
+			}
+			g++
+		}
+		if r != nil {
+			r.Close()
+		}
+	}
+	return nil
+}
+
+// Write generates the parity of the files in dir, the size of parity is by the redundancy of c.
+func Write(s fs.Storage, dir string, c *Config) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	files, err := listFiles(s, dir)
+	if err != nil {
+		return err
+	}
+
+	h := &header{
+		BlockSize: c.BlockSize,
+		Data:      stripeData,
+		Parity:    c.parity(),
+		Files:     files,
+	}
+	h.layout()
+	code, err := NewCode(h.Data, h.Parity)
+	if err != nil {
+		return err
+	}
+
+	w, err := s.Create(fs.Join(s, dir, index.MetaDir, blocksFile), time.Time{})
+	if err != nil {
+		return err
+	}
+
+	// the parity of the current set, by stripe then by parity position
+	var parity [][][]byte
+	var setIdx, setStart int
+	newSet := func() {
+		st := &h.Sets[setIdx]
+		parity = make([][][]byte, st.Stripes)
+		for t := range parity {
+			parity[t] = make([][]byte, h.Parity)
+			for p := range parity[t] {
+				parity[t][p] = make([]byte, h.BlockSize)
+			}
+		}
+	}
+	flushSet := func() error {
+		st := &h.Sets[setIdx]
+		for t := range parity {
+			for _, block := range parity[t] {
+				st.ParityCRC = appendCRC(st.ParityCRC, blockCRC(block))
+				if _, err := w.Write(block); err != nil {
+					return err
+				}
+			}
+		}
+		setStart += st.Blocks
+		setIdx++
+		return nil
+	}
+
+	hashes := make([]hash.Hash, len(files))
+	for f := range hashes {
+		hashes[f] = sha256.New()
+	}
+
+	if len(h.Sets) > 0 {
+		newSet()
+	}
+	err = readBlocks(s, dir, h, func(g, f, i int, block []byte) error {
+		if block == nil {
+			return fmt.Errorf("failed to read %s", h.Files[f].Name)
+		}
+		if remain := h.Files[f].Size - int64(i)*int64(h.BlockSize); remain < int64(len(block)) {
+			hashes[f].Write(block[:remain])
+		} else {
+			hashes[f].Write(block)
+		}
+
+		st := &h.Sets[setIdx]
+		j := g - setStart
+		st.CRC = appendCRC(st.CRC, blockCRC(block))
+		code.Add(parity[j%st.Stripes], j/st.Stripes, block)
+		if j == st.Blocks-1 {
+			if err := flushSet(); err != nil {
+				return err
+			}
+			if setIdx < len(h.Sets) {
+				newSet()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	for f := range h.Files {
+		h.Files[f].SHA256 = hex.EncodeToString(hashes[f].Sum(nil))
+	}
+	return writeHeader(s, dir, h)
+}
+
+// firstBlock returns the number of the first block of file f
+func (h *header) firstBlock(f int) int {
+	var g int
+	for i := 0; i < f; i++ {
+		g += h.Files[i].blocks(h.BlockSize)
+	}
+	return g
+}
+
+func writeHeader(s fs.Storage, dir string, h *header) error {
+	w, err := s.Create(fs.Join(s, dir, index.MetaDir, headerFile), time.Time{})
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(h); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func readHeader(s fs.Storage, dir string) (*header, error) {
+	r, err := s.Open(fs.Join(s, dir, index.MetaDir, headerFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNoParity
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var h header
+	if err := json.NewDecoder(r).Decode(&h); err != nil {
+		return nil, fmt.Errorf("invalid parity of %s: %w", dir, err)
+	}
+	return &h, nil
+}
+
+// UpToDate reports whether the parity of dir by c covers its current files, a directory without files needs no parity.
+func UpToDate(s fs.Storage, dir string, c *Config) (bool, error) {
+	files, err := listFiles(s, dir)
+	if err != nil {
+		return false, err
+	}
+	h, err := readHeader(s, dir)
+	if err == ErrNoParity {
+		return len(files) == 0, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if h.BlockSize != c.BlockSize || h.Parity != c.parity() || len(files) != len(h.Files) {
+		return false, nil
+	}
+	for i := range files {
+		f, old := &files[i], &h.Files[i]
+		if f.Name != old.Name || f.Size != old.Size || !f.ModTime.Equal(old.ModTime) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Result is the outcome of the repair of a directory, by the file names.
+type Result struct {
+	Intact int
+	// Damaged are found by the checksums of blocks, they are repaired or failed
+	Damaged  []string
+	Repaired []string
+	Failed   map[string]string
+	// Modified are changed since the parity, they are not restored
+	Modified []string
+	// BadBlocks and BadParity are the damaged data blocks and parity blocks
+	BadBlocks int
+	BadParity int
+}
+
+// Repair checks the files of dir by their parity, and rewrites the damaged ones by reconstructing their blocks.
+// The files modified since the parity are not restored.
+func Repair(s fs.Storage, dir string, dryRun bool) (*Result, error) {
+	h, err := readHeader(s, dir)
+	if err != nil {
+		return nil, err
+	}
+	code, err := NewCode(h.Data, h.Parity)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{Failed: make(map[string]string)}
+	modified := make(map[int]bool)
+	for f := range h.Files {
+		file := &h.Files[f]
+		info, err := s.Stat(fs.Join(s, dir, file.Name))
+		if err == nil && !info.ModTime().Equal(file.ModTime) {
+			modified[f] = true
+			res.Modified = append(res.Modified, file.Name)
+		}
+	}
+
+	// pass 1: find the damaged blocks
+	bad := make(map[int]bool)
+	damaged := make(map[int]bool)
+	var setIdx, setStart int
+	err = readBlocks(s, dir, h, func(g, f, _ int, block []byte) error {
+		for g-setStart >= h.Sets[setIdx].Blocks {
+			setStart += h.Sets[setIdx].Blocks
+			setIdx++
+		}
+		if modified[f] || block == nil || blockCRC(block) != getCRC(h.Sets[setIdx].CRC, g-setStart) {
+			bad[g] = true
+			if !modified[f] {
+				damaged[f] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for f := range damaged {
+		res.Damaged = append(res.Damaged, h.Files[f].Name)
+	}
+	sort.Strings(res.Damaged)
+	res.BadBlocks = len(bad)
+	res.Intact = len(h.Files) - len(damaged) - len(modified)
+	if len(damaged) == 0 || dryRun {
+		return res, nil
+	}
+
+	// pass 2: load the stripes with damaged blocks and reconstruct them
+	type stripeKey struct{ set, stripe int }
+	setOf := func(g int) (int, int) {
+		start := 0
+		for i := range h.Sets {
+			if g < start+h.Sets[i].Blocks {
+				return i, g - start
+			}
+			start += h.Sets[i].Blocks
+		}
+		return -1, 0
+	}
+	stripes := make(map[stripeKey][][]byte)
+	for g := range bad {
+		i, j := setOf(g)
+		stripes[stripeKey{i, j % h.Sets[i].Stripes}] = nil
+	}
+	for k := range stripes {
+		data := make([][]byte, h.Data)
+		// the positions beyond the blocks of the set are zero
+		for pos := range data {
+			if k.stripe+pos*h.Sets[k.set].Stripes >= h.Sets[k.set].Blocks {
+				data[pos] = make([]byte, h.BlockSize)
+			}
+		}
+		stripes[k] = data
+	}
+	err = readBlocks(s, dir, h, func(g, _, _ int, block []byte) error {
+		i, j := setOf(g)
+		data, ok := stripes[stripeKey{i, j % h.Sets[i].Stripes}]
+		if ok && !bad[g] {
+			data[j/h.Sets[i].Stripes] = block
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	parity, err := readParity(s, dir, h, func(set, stripe int) bool {
+		_, ok := stripes[stripeKey{set, stripe}]
+		return ok
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+
+	repaired := make(map[int][]byte)
+	for k, data := range stripes {
+		if err := code.Reconstruct(data, parity[k.set][k.stripe], h.BlockSize); err != nil {
+			continue
+		}
+		for pos, block := range data {
+			g := k.stripe + pos*h.Sets[k.set].Stripes
+			if g < h.Sets[k.set].Blocks {
+				repaired[h.setStart(k.set)+g] = block
+			}
+		}
+	}
+
+	for f := range damaged {
+		if err := rewrite(s, dir, h, f, bad, repaired); err != nil {
+			res.Failed[h.Files[f].Name] = err.Error()
+			continue
+		}
+		res.Repaired = append(res.Repaired, h.Files[f].Name)
+	}
+	sort.Strings(res.Repaired)
+	return res, nil
+}
+
+func (h *header) setStart(set int) int {
+	var start int
+	for i := 0; i < set; i++ {
+		start += h.Sets[i].Blocks
+	}
+	return start
+}
+
+// readParity returns the intact parity blocks of the wanted stripes by set and stripe, damaged ones are nil
+func readParity(s fs.Storage, dir string, h *header, want func(set, stripe int) bool, res *Result) (map[int]map[int][][]byte, error) {
+	r, err := s.Open(fs.Join(s, dir, index.MetaDir, blocksFile))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	parity := make(map[int]map[int][][]byte)
+	for i, st := range h.Sets {
+		parity[i] = make(map[int][][]byte)
+		for t := 0; t < st.Stripes; t++ {
+			blocks := make([][]byte, h.Parity)
+			for p := range blocks {
+				block := make([]byte, h.BlockSize)
+				if _, err := io.ReadFull(r, block); err != nil {
+					res.BadParity++
+					continue
+				}
+				if blockCRC(block) != getCRC(st.ParityCRC, t*h.Parity+p) {
+					res.BadParity++
+					continue
+				}
+				blocks[p] = block
+			}
+			if want(i, t) {
+				parity[i][t] = blocks
+			}
+		}
+	}
+	return parity, nil
+}
+
+// rewrite writes file f with the repaired blocks and checks its checksum before replacing the damaged one
+func rewrite(s fs.Storage, dir string, h *header, f int, bad map[int]bool, repaired map[int][]byte) error {
+	file := &h.Files[f]
+	first := h.firstBlock(f)
+	n := file.blocks(h.BlockSize)
+	for g := first; g < first+n; g++ {
+		if bad[g] && repaired[g] == nil {
+			return ErrTooManyErasures
+		}
+	}
+
+	target := fs.Join(s, dir, file.Name)
+	tmp := fs.Join(s, dir, index.MetaDir, file.Name+repairExt)
+	w, err := s.Create(tmp, file.ModTime)
+	if err != nil {
+		return err
+	}
+
+	var r io.ReadCloser
+	if r, err = s.Open(target); err != nil {
+		r = nil
+	}
+	hash := sha256.New()
+	remain := file.Size
+	for g := first; g < first+n; g++ {
+		block := make([]byte, h.BlockSize)
+		if r != nil {
+			_, _ = io.ReadFull(r, block)
+		}
+		if bad[g] {
+			block = repaired[g]
+		}
+		if remain < int64(len(block)) {
+			block = block[:remain]
+		}
+		remain -= int64(len(block))
+		hash.Write(block)
+		if _, err = w.Write(block); err != nil {
+			break
+		}
+	}
+	if r != nil {
+		r.Close()
+	}
+	if err != nil {
+		w.Close()
+		_ = s.Remove(tmp)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		_ = s.Remove(tmp)
+		return err
+	}
+
+	sum, _ := hex.DecodeString(file.SHA256)
+	if !bytes.Equal(hash.Sum(nil), sum) {
+		_ = s.Remove(tmp)
+		return errors.New("checksum mismatch after repair")
+	}
+
+	if err := s.Remove(target); err != nil && !os.IsNotExist(err) {
+		_ = s.Remove(tmp)
+		return err
+	}
+	return s.Rename(tmp, target)
+}
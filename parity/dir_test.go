@@ -0,0 +1,219 @@
+package parity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+// the blocks of the test directory: a.bin 0-62, b.bin 63-83, c.bin 84, so 5 stripes of a set
+const testBlockSize = 16
+
+var testFiles = []struct {
+	name string
+	size int
+}{
+	{"a.bin", 1000},
+	{"b.bin", 333},
+	{"c.bin", 7},
+}
+
+// writeDir writes the test files into a new directory with their parity, 2 parity blocks a stripe
+func writeDir(t *testing.T) (string, map[string][]byte) {
+	dir, err := ioutil.TempDir("", "parity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	r := rand.New(rand.NewSource(1))
+	contents := make(map[string][]byte)
+	for _, f := range testFiles {
+		data := make([]byte, f.size)
+		r.Read(data)
+		if err := ioutil.WriteFile(filepath.Join(dir, f.name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		contents[f.name] = data
+	}
+
+	if err := Write(fs.NewLocal(), dir, &Config{Redundancy: 10, BlockSize: testBlockSize}); err != nil {
+		t.Fatal(err)
+	}
+	return dir, contents
+}
+
+// damage overwrites the blocks of a file, keeping its modification time so it is not taken as modified
+func damage(t *testing.T, path string, blocks ...int) {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks {
+		if _, err := f.WriteAt(bytes.Repeat([]byte{0xff}, testBlockSize), int64(b*testBlockSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func span(from, to int) []int {
+	var blocks []int
+	for b := from; b < to; b++ {
+		blocks = append(blocks, b)
+	}
+	return blocks
+}
+
+func TestRepair(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+		damage func(t *testing.T, dir string)
+		// the files damaged, repaired and failed
+		damaged, repaired, failed []string
+	}{
+		{
+			name:   "intact",
+			damage: func(t *testing.T, dir string) {},
+		},
+		{
+			name:     "one block",
+			damage:   func(t *testing.T, dir string) { damage(t, filepath.Join(dir, "a.bin"), 17) },
+			damaged:  []string{"a.bin"},
+			repaired: []string{"a.bin"},
+		},
+		{
+			// stripes * parity consecutive blocks hit every stripe twice
+			name:     "longest run",
+			damage:   func(t *testing.T, dir string) { damage(t, filepath.Join(dir, "a.bin"), span(10, 20)...) },
+			damaged:  []string{"a.bin"},
+			repaired: []string{"a.bin"},
+		},
+		{
+			name: "run across files",
+			damage: func(t *testing.T, dir string) {
+				damage(t, filepath.Join(dir, "a.bin"), span(58, 62)...)
+				damage(t, filepath.Join(dir, "b.bin"), span(0, 4)...)
+			},
+			damaged:  []string{"a.bin", "b.bin"},
+			repaired: []string{"a.bin", "b.bin"},
+		},
+		{
+			name:     "removed file",
+			damage:   func(t *testing.T, dir string) { os.Remove(filepath.Join(dir, "c.bin")) },
+			damaged:  []string{"c.bin"},
+			repaired: []string{"c.bin"},
+		},
+		{
+			name: "data and parity",
+			damage: func(t *testing.T, dir string) {
+				damage(t, filepath.Join(dir, "a.bin"), 0)
+				// the first parity block of stripe 0
+				damage(t, filepath.Join(dir, index.MetaDir, blocksFile), 0)
+			},
+			damaged:  []string{"a.bin"},
+			repaired: []string{"a.bin"},
+		},
+		{
+			name:    "dry run",
+			dryRun:  true,
+			damage:  func(t *testing.T, dir string) { damage(t, filepath.Join(dir, "b.bin"), 3) },
+			damaged: []string{"b.bin"},
+		},
+		{
+			name:    "run too long",
+			damage:  func(t *testing.T, dir string) { damage(t, filepath.Join(dir, "a.bin"), span(10, 21)...) },
+			damaged: []string{"a.bin"},
+			failed:  []string{"a.bin"},
+		},
+		{
+			// 3 blocks of stripe 0 are lost with only 2 parity blocks
+			name:    "stripes apart",
+			damage:  func(t *testing.T, dir string) { damage(t, filepath.Join(dir, "a.bin"), 0, 5, 10) },
+			damaged: []string{"a.bin"},
+			failed:  []string{"a.bin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, contents := writeDir(t)
+			original := make(map[string][]byte)
+			for name, data := range contents {
+				original[name] = data
+			}
+			tt.damage(t, dir)
+			before := make(map[string][]byte)
+			for name := range contents {
+				before[name], _ = ioutil.ReadFile(filepath.Join(dir, name))
+			}
+
+			res, err := Repair(fs.NewLocal(), dir, tt.dryRun)
+			if err != nil {
+				t.Fatalf("Repair() = %v", err)
+			}
+			if !reflect.DeepEqual(res.Damaged, tt.damaged) {
+				t.Errorf("Damaged = %v, want %v", res.Damaged, tt.damaged)
+			}
+			if !reflect.DeepEqual(res.Repaired, tt.repaired) {
+				t.Errorf("Repaired = %v, want %v", res.Repaired, tt.repaired)
+			}
+			var failed []string
+			for name := range res.Failed {
+				failed = append(failed, name)
+			}
+			if !reflect.DeepEqual(failed, tt.failed) {
+				t.Errorf("Failed = %v, want %v", failed, tt.failed)
+			}
+
+			// the repaired files are restored, the others are untouched
+			for name, want := range original {
+				repaired := false
+				for _, r := range res.Repaired {
+					repaired = repaired || r == name
+				}
+				if !repaired {
+					want = before[name]
+				}
+				got, _ := ioutil.ReadFile(filepath.Join(dir, name))
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s is changed by the repair", name)
+				}
+			}
+		})
+	}
+}
+
+func TestUpToDate(t *testing.T) {
+	dir, _ := writeDir(t)
+	s, c := fs.NewLocal(), &Config{Redundancy: 10, BlockSize: testBlockSize}
+
+	if ok, err := UpToDate(s, dir, c); err != nil || !ok {
+		t.Fatalf("UpToDate() = %v, %v, want true", ok, err)
+	}
+	if ok, _ := UpToDate(s, dir, &Config{Redundancy: 50, BlockSize: testBlockSize}); ok {
+		t.Error("UpToDate() = true for another redundancy")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "d.bin"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := UpToDate(s, dir, c); ok {
+		t.Error("UpToDate() = true with a new file")
+	}
+}
@@ -0,0 +1,93 @@
+package parity
+
+// arithmetic of GF(2^8) by the polynomial x^8+x^4+x^3+x^2+1
+const polynomial = 0x11d
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= polynomial
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func gfInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// mulAdd adds c times src to dst
+func mulAdd(dst, src []byte, c byte) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i, b := range src {
+			dst[i] ^= b
+		}
+		return
+	}
+
+	var row [256]byte
+	lc := int(logTable[c])
+	for b := 1; b < 256; b++ {
+		row[b] = expTable[lc+int(logTable[b])]
+	}
+	for i, b := range src {
+		dst[i] ^= row[b]
+	}
+}
+
+// invert returns the inverse of the square matrix m, or nil if it is singular
+func invert(m [][]byte) [][]byte {
+	n := len(m)
+	a := make([][]byte, n)
+	for i := range m {
+		a[i] = make([]byte, 2*n)
+		copy(a[i], m[i])
+		a[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+
+		inv := gfInv(a[col][col])
+		for j := range a[col] {
+			a[col][j] = gfMul(a[col][j], inv)
+		}
+		for i := 0; i < n; i++ {
+			if i != col && a[i][col] != 0 {
+				mulAdd(a[i], a[col], a[i][col])
+			}
+		}
+	}
+
+	inv := make([][]byte, n)
+	for i := range a {
+		inv[i] = a[i][n:]
+	}
+	return inv
+}
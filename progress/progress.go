@@ -0,0 +1,305 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	barWidth    = 30
+	ttyInterval = 200 * time.Millisecond
+	logInterval = 10 * time.Second
+)
+
+type Counter int
+
+const (
+	Indexed Counter = iota
+	Hashed
+	Compared
+	Copied
+	// Processed is the work measured against the total for the ETA
+	Processed
+	numCounters
+)
+
+var counterNames = [numCounters]string{"indexed", "hashed", "compared", "copied", "processed"}
+
+func (c Counter) String() string {
+	return counterNames[c]
+}
+
+// Progress counts the files and bytes of a run, and renders them as a progress bar on a terminal
+// or as periodic log events otherwise.
+type Progress struct {
+	name       string
+	start      time.Time
+	files      [numCounters]int64
+	bytes      [numCounters]int64
+	totalFiles int64
+	totalBytes int64
+
+	out  io.Writer
+	tty  bool
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// line is the bar drawn last
+	line string
+}
+
+var current atomic.Value
+
+var (
+	// screen serializes the bar and the log entries on the terminal
+	screen sync.Mutex
+	// drawn is the progress whose bar is on the terminal, it is cleared for the log entries
+	drawn *Progress
+)
+
+func init() {
+	current.Store((*Progress)(nil))
+}
+
+func load() *Progress {
+	return current.Load().(*Progress)
+}
+
+// Start begins reporting the progress of the run called name, the counters of the package go to it until Stop.
+func Start(name string) *Progress {
+	p := &Progress{
+		name:  name,
+		start: time.Now(),
+		out:   os.Stderr,
+		tty:   isTerminal(os.Stderr),
+		stop:  make(chan struct{}),
+	}
+
+	Stop()
+	current.Store(p)
+
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// Stop renders the last state of the current progress and stops reporting.
+func Stop() {
+	p := load()
+	if p == nil {
+		return
+	}
+	current.Store((*Progress)(nil))
+
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// Add counts files and bytes to c of the current progress.
+func Add(c Counter, files, bytes int64) {
+	if p := load(); p != nil {
+		atomic.AddInt64(&p.files[c], files)
+		atomic.AddInt64(&p.bytes[c], bytes)
+	}
+}
+
+// AddTotal grows the expected work of the current progress.
+func AddTotal(files, bytes int64) {
+	if p := load(); p != nil {
+		atomic.AddInt64(&p.totalFiles, files)
+		atomic.AddInt64(&p.totalBytes, bytes)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *Progress) run() {
+	defer p.wg.Done()
+
+	interval := logInterval
+	if p.tty {
+		interval = ttyInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.render()
+		case <-p.stop:
+			p.render()
+			if p.tty {
+				// the last bar stays
+				screen.Lock()
+				fmt.Fprintln(p.out)
+				drawn = nil
+				screen.Unlock()
+			}
+			return
+		}
+	}
+}
+
+type snapshot struct {
+	files      [numCounters]int64
+	bytes      [numCounters]int64
+	totalFiles int64
+	totalBytes int64
+	elapsed    time.Duration
+}
+
+func (p *Progress) snapshot() *snapshot {
+	s := &snapshot{
+		totalFiles: atomic.LoadInt64(&p.totalFiles),
+		totalBytes: atomic.LoadInt64(&p.totalBytes),
+		elapsed:    time.Since(p.start),
+	}
+	for c := Counter(0); c < numCounters; c++ {
+		s.files[c] = atomic.LoadInt64(&p.files[c])
+		s.bytes[c] = atomic.LoadInt64(&p.bytes[c])
+	}
+	return s
+}
+
+// ratio is the finished part of the total, by bytes if they are known
+func (s *snapshot) ratio() float64 {
+	if s.totalBytes > 0 {
+		return float64(s.bytes[Processed]) / float64(s.totalBytes)
+	}
+	if s.totalFiles > 0 {
+		return float64(s.files[Processed]) / float64(s.totalFiles)
+	}
+	return 0
+}
+
+// throughput is the processed bytes per second
+func (s *snapshot) throughput() float64 {
+	if s.elapsed <= 0 {
+		return 0
+	}
+	return float64(s.bytes[Processed]) / s.elapsed.Seconds()
+}
+
+// eta returns the remaining time, or 0 if it is unknown yet
+func (s *snapshot) eta() time.Duration {
+	ratio := s.ratio()
+	if ratio <= 0 || ratio >= 1 {
+		return 0
+	}
+	return time.Duration(float64(s.elapsed) * (1 - ratio) / ratio).Round(time.Second)
+}
+
+func (p *Progress) render() {
+	s := p.snapshot()
+	if p.tty {
+		screen.Lock()
+		p.line = s.line(p.name)
+		fmt.Fprint(p.out, "\r\033[K", p.line)
+		drawn = p
+		screen.Unlock()
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("run", p.name),
+		zap.Int64("files", s.files[Processed]),
+		zap.Int64("totalFiles", s.totalFiles),
+		zap.Int64("bytes", s.bytes[Processed]),
+		zap.Int64("totalBytes", s.totalBytes),
+		zap.Float64("bytesPerSecond", s.throughput()),
+		zap.Duration("elapsed", s.elapsed.Round(time.Second)),
+		zap.Duration("eta", s.eta()),
+	}
+	for c := Counter(0); c < Processed; c++ {
+		fields = append(fields,
+			zap.Int64(c.String()+"Files", s.files[c]),
+			zap.Int64(c.String()+"Bytes", s.bytes[c]))
+	}
+	zap.L().Info("progress", fields...)
+}
+
+func (s *snapshot) line(name string) string {
+	ratio := s.ratio()
+	filled := int(ratio * barWidth)
+	if filled > barWidth {
+		filled = barWidth
+	}
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s [%s%s] %3.0f%% %d/%d files %s/%s %s/s",
+		name,
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		ratio*100,
+		s.files[Processed], s.totalFiles,
+		humanBytes(s.bytes[Processed]), humanBytes(s.totalBytes),
+		humanBytes(int64(s.throughput())))
+	if eta := s.eta(); eta > 0 {
+		fmt.Fprintf(b, " ETA %s", eta)
+	}
+	fmt.Fprintf(b, " | indexed %d hashed %s compared %d copied %d",
+		s.files[Indexed], humanBytes(s.bytes[Hashed]), s.files[Compared], s.files[Copied])
+	return b.String()
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// core clears the bar for the log entries, so they are never written into it
+type core struct {
+	zapcore.Core
+}
+
+// WrapCore wraps the core of the logger writing to the terminal of the bar, by zap.WrapCore.
+func WrapCore(c zapcore.Core) zapcore.Core {
+	return core{c}
+}
+
+func (c core) With(fields []zapcore.Field) zapcore.Core {
+	return core{c.Core.With(fields)}
+}
+
+func (c core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	screen.Lock()
+	defer screen.Unlock()
+
+	if drawn == nil {
+		return c.Core.Write(ent, fields)
+	}
+	fmt.Fprint(drawn.out, "\r\033[K")
+	err := c.Core.Write(ent, fields)
+	// the bar follows the entry
+	fmt.Fprint(drawn.out, drawn.line)
+	return err
+}
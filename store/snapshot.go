@@ -0,0 +1,123 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotExt = ".json"
+	// Latest selects the newest snapshot
+	Latest = "latest"
+)
+
+// Entry is a file of a snapshot.
+type Entry struct {
+	// Path is the original path of the file
+	Path    string    `json:"path"`
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Shooting and Model are of the metadata, so snapshots are filtered without reading the objects
+	Shooting *time.Time `json:"shooting,omitempty"`
+	Model    string     `json:"model,omitempty"`
+}
+
+// Snapshot maps the original paths of a backup to the objects.
+type Snapshot struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Inputs []string  `json:"inputs"`
+	Files  []Entry   `json:"files"`
+}
+
+func NewSnapshot(inputs []string) *Snapshot {
+	now := time.Now()
+	return &Snapshot{ID: snapshotID(now), Time: now, Inputs: inputs}
+}
+
+// Input returns the input directory which contains p, or an empty string.
+func (snap *Snapshot) Input(p string) string {
+	for _, in := range snap.Inputs {
+		if p == in || strings.HasPrefix(p, strings.TrimSuffix(in, "/")+"/") ||
+			strings.HasPrefix(p, strings.TrimSuffix(in, string(os.PathSeparator))+string(os.PathSeparator)) {
+			return in
+		}
+	}
+	return ""
+}
+
+// SaveSnapshot writes snap to snapshots/<id>.json.
+func (s *Store) SaveSnapshot(snap *Snapshot) error {
+	sort.Slice(snap.Files, func(i, j int) bool { return snap.Files[i].Path < snap.Files[j].Path })
+
+	// a snapshot is never overwritten by another of the same ID
+	p := s.join(snapshotsDir, snap.ID+snapshotExt)
+	if _, err := s.storage.Stat(p); err == nil {
+		return fmt.Errorf("snapshot %s exists", snap.ID)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	w, err := s.storage.Create(p, snap.Time)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(snap); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Snapshots returns the IDs of the snapshots from the oldest.
+func (s *Store) Snapshots() ([]string, error) {
+	entries, err := s.storage.List(s.join(snapshotsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), snapshotExt) {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), snapshotExt))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// LoadSnapshot reads the snapshot of id, or the newest one by Latest.
+func (s *Store) LoadSnapshot(id string) (*Snapshot, error) {
+	if id == Latest {
+		ids, err := s.Snapshots()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no snapshot in %s", s.root)
+		}
+		id = ids[len(ids)-1]
+	}
+
+	r, err := s.storage.Open(s.join(snapshotsDir, id+snapshotExt))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", id, err)
+	}
+	return &snap, nil
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/enjoypi/bkpic/fs"
+)
+
+const (
+	objectsDir   = "objects"
+	snapshotsDir = "snapshots"
+	partialExt   = ".partial"
+)
+
+// Store keeps every unique file once by its SHA256 in objects/, and the snapshots of backups in snapshots/.
+type Store struct {
+	storage fs.Storage
+	root    string
+}
+
+// Open returns the store at location, which is a local directory or any location of fs.Parse.
+func Open(location string) (*Store, error) {
+	s, root, err := fs.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if fs.IsLocal(s) {
+		if root, err = filepath.Abs(root); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{storage: s, root: root}, nil
+}
+
+// Root returns the directory of the store in its storage.
+func (s *Store) Root() string {
+	return s.root
+}
+
+// Storage returns where the store is kept.
+func (s *Store) Storage() fs.Storage {
+	return s.storage
+}
+
+func (s *Store) join(elem ...string) string {
+	return fs.Join(s.storage, append([]string{s.root}, elem...)...)
+}
+
+// ObjectPath returns the path of the object by sum, objects/ab/abcdef...
+func (s *Store) ObjectPath(sum []byte) string {
+	h := hex.EncodeToString(sum)
+	return s.join(objectsDir, h[:2], h)
+}
+
+// Has reports whether the object of sum is stored.
+func (s *Store) Has(sum []byte) (bool, error) {
+	_, err := s.storage.Stat(s.ObjectPath(sum))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Put copies file of from as the object of sum, it is renamed in place after the whole content is written
+// and checked by sum, so a bad read is never kept as the object.
+func (s *Store) Put(from fs.Storage, file string, sum []byte) error {
+	if len(sum) == 0 {
+		return fmt.Errorf("no checksum of %s", file)
+	}
+	info, err := from.Stat(file)
+	if err != nil {
+		return err
+	}
+	r, err := from.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	object := s.ObjectPath(sum)
+	partial := object + partialExt
+	w, err := s.storage.Create(partial, info.ModTime())
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(w, io.TeeReader(r, h)); err != nil {
+		w.Close()
+		_ = s.storage.Remove(partial)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		_ = s.storage.Remove(partial)
+		return err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, sum) {
+		_ = s.storage.Remove(partial)
+		return fmt.Errorf("checksum mismatch of %s: %x, expected %x", file, got, sum)
+	}
+	return s.storage.Rename(partial, object)
+}
+
+// OpenObject opens the content of the object by sum.
+func (s *Store) OpenObject(sum []byte) (io.ReadCloser, error) {
+	return s.storage.Open(s.ObjectPath(sum))
+}
+
+// snapshotID names a snapshot by its UTC time to the nanosecond, so the IDs sort by time and two backups of a second differ
+func snapshotID(t time.Time) string {
+	return t.UTC().Format("20060102T150405.000000000Z")
+}
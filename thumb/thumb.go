@@ -0,0 +1,335 @@
+package thumb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/enjoypi/bkpic/fs"
+	"github.com/enjoypi/bkpic/index"
+)
+
+// Size is the longer side of a thumbnail in pixels.
+const Size = 320
+
+var ErrUnsupported = errors.New("no thumbnail of the media type")
+
+// Cache keeps the thumbnails of media by the SHA256 of their contents,
+// so a file is thumbnailed once whatever its path and the cache is shared by all archives.
+type Cache struct {
+	dir string
+}
+
+// DefaultDir is the cache in the cache directory of the user.
+func DefaultDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "bkpic", "thumbs"), nil
+}
+
+// Open opens the cache in the local dir, the default one if dir is empty.
+func Open(dir string) (*Cache, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Path is the thumbnail of the content of sum, like ab/abcdef….jpg.
+func (c *Cache) Path(sum []byte) string {
+	h := hex.EncodeToString(sum)
+	return filepath.Join(c.dir, h[:2], h+".jpg")
+}
+
+// Has reports whether the thumbnail of m is cached, m is hashed if not yet.
+func (c *Cache) Has(m *index.Medium) bool {
+	m.SumSHA256()
+	if len(m.SHA256) == 0 {
+		return false
+	}
+	_, err := os.Stat(c.Path(m.SHA256))
+	return err == nil
+}
+
+// Get returns the thumbnail of m, it is generated if not cached:
+// images are scaled down, videos by a frame of ffmpeg.
+func (c *Cache) Get(m *index.Medium) (string, error) {
+	m.SumSHA256()
+	if len(m.SHA256) == 0 {
+		return "", fmt.Errorf("failed to hash %s", m.FullPath)
+	}
+	p := c.Path(m.SHA256)
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
+
+	var data []byte
+	var err error
+	switch mime := mimeType(m); {
+	case strings.HasPrefix(mime, "image/"):
+		if data, err = scaleImage(m); err != nil {
+			// the formats unknown to Go, like HEIC, by ffmpeg
+			zap.L().Debug("image.Decode", zap.String("file", m.FullPath), zap.Error(err))
+			data, err = frame(m, false)
+		}
+	case strings.HasPrefix(mime, "video/"):
+		data, err = frame(m, true)
+	default:
+		return "", ErrUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := write(p, data); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func mimeType(m *index.Medium) string {
+	if meta := m.Meta(); meta != nil {
+		return meta.MIMEType
+	}
+	return ""
+}
+
+// write writes the thumbnail by a temporary file, so a concurrent reader never gets it half written
+func write(p string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), os.FileMode(0700)); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func scaleImage(m *index.Medium) ([]byte, error) {
+	r, err := m.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	// the pixels of a JPEG are as the sensor was, the EXIF orientation turns them upright
+	img = Scale(img, Size)
+	if meta := m.Meta(); meta != nil {
+		img = Orient(img, meta.Orientation())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Scale shrinks img to fit in a square of size by the nearest pixels, it is good enough for a preview.
+func Scale(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	// the decoded JPEGs and PNGs are read by their pixels, not by the colors of At
+	switch src := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < th; y++ {
+			sy := b.Min.Y + y*h/th
+			row := dst.Pix[y*dst.Stride:]
+			for x := 0; x < tw; x++ {
+				sx := b.Min.X + x*w/tw
+				yi, ci := src.YOffset(sx, sy), src.COffset(sx, sy)
+				cr, cg, cb := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+				row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = cr, cg, cb, 0xff
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < th; y++ {
+			sy := b.Min.Y + y*h/th
+			row := dst.Pix[y*dst.Stride:]
+			for x := 0; x < tw; x++ {
+				i := src.PixOffset(b.Min.X+x*w/tw, sy)
+				copy(row[4*x:4*x+4], src.Pix[i:i+4])
+			}
+		}
+	default:
+		for y := 0; y < th; y++ {
+			for x := 0; x < tw; x++ {
+				dst.Set(x, y, img.At(b.Min.X+x*w/tw, b.Min.Y+y*h/th))
+			}
+		}
+	}
+	return dst
+}
+
+// Orient turns img upright by its EXIF orientation from 1 to 8, 1 is upright already.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	// a thumbnail is scaled to RGBA already
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// the pixel of src at x, y of the result
+	var at func(x, y int) (int, int)
+	switch orientation {
+	case 2: // mirror horizontal
+		at = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // rotate 180
+		at = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // mirror vertical
+		at = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // mirror horizontal and rotate 270 CW
+		at = func(x, y int) (int, int) { return y, x }
+	case 6: // rotate 90 CW
+		at = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // mirror horizontal and rotate 90 CW
+		at = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // rotate 270 CW
+		at = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dw; x++ {
+			sx, sy := at(x, y)
+			i := src.PixOffset(b.Min.X+sx, b.Min.Y+sy)
+			copy(row[4*x:4*x+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// frame takes a frame of m by ffmpeg, the poster of a video is a second in, not to be a black fade-in
+func frame(m *index.Medium, video bool) ([]byte, error) {
+	input := m.FullPath
+	if !fs.IsLocal(m.Storage()) {
+		// ffmpeg seeks in the file, the moov box of a video may be at the end
+		tmp, err := localCopy(m)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp)
+		input = tmp
+	}
+
+	seeks := []string{""}
+	if video {
+		seeks = []string{"1", ""}
+	}
+	var err error
+	for _, seek := range seeks {
+		var data []byte
+		if data, err = ffmpeg(input, seek); err == nil && len(data) > 0 {
+			return data, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no frame of %s", m.FullPath)
+	}
+	return nil, err
+}
+
+func ffmpeg(input string, seek string) ([]byte, error) {
+	var args []string
+	if seek != "" {
+		args = append(args, "-ss", seek)
+	}
+	scale := "scale=w=" + strconv.Itoa(Size) + ":h=" + strconv.Itoa(Size) + ":force_original_aspect_ratio=decrease"
+	args = append(args, "-v", "error", "-i", input, "-frames:v", "1", "-vf", scale, "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+
+	cmd := exec.Command("ffmpeg", args...)
+	zap.L().Debug(cmd.String())
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg %s: %w: %s", input, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func localCopy(m *index.Medium) (string, error) {
+	r, err := m.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	f, err := ioutil.TempFile("", "bkpic-*"+filepath.Ext(m.FullPath))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,135 @@
+package thumb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/enjoypi/bkpic/index"
+)
+
+// exifOrientation6 is the APP1 segment of EXIF whose only tag is Orientation 6, rotate 90 CW
+var exifOrientation6 = []byte{
+	0xff, 0xe1, 0x00, 0x22, 'E', 'x', 'i', 'f', 0, 0,
+	'M', 'M', 0x00, 0x2a, 0x00, 0x00, 0x00, 0x08,
+	0x00, 0x01,
+	0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+// writeRotated writes a JPEG of 640x320 taken by a camera turned right, its left half red and right half blue
+func writeRotated(t *testing.T, dir string) string {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 320))
+	draw.Draw(img, image.Rect(0, 0, 320, 320), image.NewUniform(color.RGBA{R: 0xff, A: 0xff}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(320, 0, 640, 320), image.NewUniform(color.RGBA{B: 0xff, A: 0xff}), image.Point{}, draw.Src)
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the EXIF follows SOI
+	data := append(append([]byte{0xff, 0xd8}, exifOrientation6...), buf.Bytes()[2:]...)
+	file := filepath.Join(dir, "rotated.jpg")
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestOrientation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exiftool is faked by a shell script")
+	}
+	dir, err := ioutil.TempDir("", "thumb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeRotated(t, dir)
+
+	// exiftool tells the orientation as it prints it
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0700); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho '[{\"SourceFile\": \"" + file + "\", \"File:MIMEType\": \"image/jpeg\", \"EXIF:Orientation\": \"Rotate 90 CW\"}]'\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "exiftool"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	c, err := Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := index.NewMedium(file)
+	if o := m.Meta().Orientation(); o != 6 {
+		t.Fatalf("Orientation() = %d, want 6", o)
+	}
+	p, err := c.Get(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// upright, the left of the sensor is the top
+	if b := img.Bounds(); b.Dx() != 160 || b.Dy() != 320 {
+		t.Fatalf("thumbnail is %dx%d, want 160x320", b.Dx(), b.Dy())
+	}
+	for _, tt := range []struct {
+		x, y int
+		red  bool
+	}{{80, 40, true}, {80, 280, false}} {
+		r, _, b, _ := img.At(tt.x, tt.y).RGBA()
+		if (r > b) != tt.red {
+			t.Errorf("pixel %d, %d = %v, want red %v", tt.x, tt.y, img.At(tt.x, tt.y), tt.red)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2 of the pixels numbered by their positions
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	copy(img.Pix, []byte{1, 2, 3, 4, 5, 6})
+
+	tests := map[int][]byte{
+		1: {1, 2, 3, 4, 5, 6},
+		2: {3, 2, 1, 6, 5, 4},
+		3: {6, 5, 4, 3, 2, 1},
+		4: {4, 5, 6, 1, 2, 3},
+		5: {1, 4, 2, 5, 3, 6},
+		6: {4, 1, 5, 2, 6, 3},
+		7: {6, 3, 5, 2, 4, 1},
+		8: {3, 6, 2, 5, 1, 4},
+	}
+	for orientation, want := range tests {
+		got := Orient(img, orientation)
+		b := got.Bounds()
+		var pix []byte
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				pix = append(pix, color.GrayModel.Convert(got.At(x, y)).(color.Gray).Y)
+			}
+		}
+		if !bytes.Equal(pix, want) {
+			t.Errorf("Orient(%d) = %v, want %v", orientation, pix, want)
+		}
+	}
+}